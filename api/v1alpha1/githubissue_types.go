@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,9 +26,9 @@ import (
 
 // GithubIssueSpec defines the desired state of GithubIssue
 type GithubIssueSpec struct {
-	// Repo is the URL of the repository
+	// Repo is the URL of the repository, on github.com or on a Github Enterprise Server host configured in the operator
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Pattern=`^https://github.com/[a-z-A-Z0-9-_]+/[a-z-A-Z0-9-_]+$`
+	// +kubebuilder:validation:Pattern=`^https://[a-zA-Z0-9.-]+(:[0-9]+)?/[a-z-A-Z0-9-_.]+/[a-z-A-Z0-9-_.]+$`
 	Repo string `json:"repo"`
 	// Title is the title of the issue to track
 	// +kubebuilder:validation:Required
@@ -35,8 +36,55 @@ type GithubIssueSpec struct {
 	// Description is the description of the issue to track
 	// +kubebuilder:validation:Required
 	Description string `json:"description"`
+	// IssueNumber is the number of an existing issue of the repository to track, instead of the one matching
+	// the Title or created by the operator. Its title and description are set as in the Spec. It is immutable
+	// once set
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	IssueNumber int64 `json:"issueNumber,omitempty"`
+	// CredentialsSecretRef is the Secret, in the same namespace, with the Github token (in the "token" key)
+	// used for this issue. If not set, the operator credentials are used
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+	// Labels are the names of the labels that the operator sets on the issue. The labels added on Github
+	// by others are kept
+	// +optional
+	Labels []string `json:"labels,omitempty"`
+	// Assignees are the logins of the users that the operator assigns to the issue. The users assigned on
+	// Github by others are kept
+	// +optional
+	Assignees []string `json:"assignees,omitempty"`
+	// Milestone is the title of an existing milestone of the repository that the operator sets on the issue
+	// +optional
+	Milestone string `json:"milestone,omitempty"`
+	// State is the state the operator keeps the issue in. The state is not managed if empty
+	// +kubebuilder:validation:Enum=open;closed
+	// +optional
+	State string `json:"state,omitempty"`
+	// StateReason is the reason why the issue is closed. An issue closed without a reason is closed as completed
+	// +kubebuilder:validation:Enum=completed;not_planned
+	// +optional
+	StateReason string `json:"stateReason,omitempty"`
+	// DeletionPolicy is what the operator does to the issue when the resource is deleted: Close (the default),
+	// CloseAsNotPlanned, CloseWithComment (with DeletionComment), Lock (close and lock the conversation) or
+	// Orphan (leave the issue untouched)
+	// +kubebuilder:validation:Enum=Close;CloseAsNotPlanned;CloseWithComment;Lock;Orphan
+	// +kubebuilder:default=Close
+	// +optional
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+	// DeletionComment is the comment added to the issue closed by the CloseWithComment DeletionPolicy
+	// +optional
+	DeletionComment string `json:"deletionComment,omitempty"`
 }
 
+const (
+	DELETION_POLICY_CLOSE                = "Close"
+	DELETION_POLICY_CLOSE_AS_NOT_PLANNED = "CloseAsNotPlanned"
+	DELETION_POLICY_CLOSE_WITH_COMMENT   = "CloseWithComment"
+	DELETION_POLICY_LOCK                 = "Lock"
+	DELETION_POLICY_ORPHAN               = "Orphan"
+)
+
 // GithubIssueStatus defines the observed state of GithubIssue
 type GithubIssueStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
@@ -44,6 +92,82 @@ type GithubIssueStatus struct {
 	// TrackedIssueId is the linked ticket number
 	// +kubebuilder:default:=0
 	TrackedIssueId int64 `json:"tracked_issue_id"`
+
+	// LinkedPullRequests are the pull requests linked to the tracked issue
+	// +optional
+	LinkedPullRequests []LinkedPullRequest `json:"linked_pull_requests,omitempty"`
+
+	// Labels, Assignees and Milestone are the ones of the tracked issue on Github
+	// +optional
+	Labels []string `json:"labels,omitempty"`
+	// +optional
+	Assignees []string `json:"assignees,omitempty"`
+	// +optional
+	Milestone string `json:"milestone,omitempty"`
+
+	// ManagedLabels, ManagedAssignees and ManagedMilestone are the ones set by the operator, which it removes
+	// from the issue when they are removed from the Spec
+	// +optional
+	ManagedLabels []string `json:"managed_labels,omitempty"`
+	// +optional
+	ManagedAssignees []string `json:"managed_assignees,omitempty"`
+	// +optional
+	ManagedMilestone string `json:"managed_milestone,omitempty"`
+	// UnassignableAssignees are the assignees of the Spec that Github did not assign, because they cannot be
+	// assigned to the issues of the repository. They are requested again when the Spec assignees change
+	// +optional
+	UnassignableAssignees []string `json:"unassignable_assignees,omitempty"`
+
+	// MergeCommitSHA is the merge commit of the linked pull request that resolved the issue
+	// +optional
+	MergeCommitSHA string `json:"merge_commit_sha,omitempty"`
+	// MergedAt is when the linked pull request that resolved the issue was merged
+	// +optional
+	MergedAt *metav1.Time `json:"merged_at,omitempty"`
+
+	// DeletionPhase is the last step of the DeletionPolicy completed on the issue after the resource was deleted
+	// +kubebuilder:validation:Enum=Commented;Closed;Completed
+	// +optional
+	DeletionPhase string `json:"deletion_phase,omitempty"`
+	// DeletionAttempts is the number of failed attempts to apply the DeletionPolicy
+	// +optional
+	DeletionAttempts int32 `json:"deletion_attempts,omitempty"`
+}
+
+const (
+	DELETION_PHASE_COMMENTED = "Commented"
+	DELETION_PHASE_CLOSED    = "Closed"
+	DELETION_PHASE_COMPLETED = "Completed"
+)
+
+// LinkedPullRequest is a pull request linked to the tracked issue
+type LinkedPullRequest struct {
+	// Number is the number of the pull request in its repository
+	Number int64 `json:"number"`
+	// URL is the web URL of the pull request
+	URL string `json:"url"`
+	// State is the state of the pull request. It is empty if the operator can't access the pull request
+	// +kubebuilder:validation:Enum=open;closed;merged
+	// +optional
+	State string `json:"state,omitempty"`
+	// Draft is true if the pull request is not ready for review
+	// +optional
+	Draft bool `json:"draft,omitempty"`
+	// Author is the login of the user that opened the pull request
+	// +optional
+	Author string `json:"author,omitempty"`
+	// ReviewDecision summarizes the latest review of each reviewer: APPROVED, CHANGES_REQUESTED or
+	// REVIEW_REQUIRED if not approved yet. It is empty once the pull request is closed
+	// +optional
+	ReviewDecision string `json:"review_decision,omitempty"`
+	// HeadSHA is the commit at the head of the pull request branch
+	// +optional
+	HeadSHA string `json:"head_sha,omitempty"`
+	// Checks combines the commit statuses and the check runs of the head commit. It is empty if the commit
+	// has no checks, or once the pull request is closed
+	// +kubebuilder:validation:Enum=success;failure;pending
+	// +optional
+	Checks string `json:"checks,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -20,6 +20,10 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -37,13 +41,50 @@ var githubissuelog = logf.Log.WithName("githubissue-resource")
 // +k8s:deepcopy-gen=false
 type GithubIssueValidator struct {
 	client client.Client
+	// httpClient checks that the repositories are reachable
+	httpClient *http.Client
+	// hosts are the web hosts, besides github.com, of the repositories the operator can reach
+	hosts map[string]bool
+	// issueLookup checks that the issues to adopt exist
+	issueLookup IssueLookup
 }
 
+// IssueLookup returns whether the issue exists in the repository, and is not a pull request, as seen through
+// the Github API with the operator credentials
+type IssueLookup func(ctx context.Context, repo string, number int64) (bool, error)
+
 var validator *GithubIssueValidator
 
-func (r *GithubIssue) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// githubHost is the host of the repositories on github.com, always served by the operator
+const githubHost = "github.com"
+
+// repoValidationTimeout is the deadline of the request checking that a repository is reachable
+const repoValidationTimeout = 10 * time.Second
+
+// Github limits on the issue metadata
+const (
+	maxLabels      = 100
+	maxLabelLength = 50
+	maxAssignees   = 10
+)
+
+// githubLoginRegexp matches the Github logins: alphanumeric characters or single hyphens, not at the start
+// or at the end, up to 39 characters
+var githubLoginRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,37}[a-zA-Z0-9])?$`)
+
+// SetupWebhookWithManager registers the webhook. transport is used to reach the repositories, the same used to
+// reach Github from the controller (http.DefaultTransport if nil). hosts are the Github Enterprise Server hosts
+// configured in the controller, the repositories on other hosts than github.com are rejected. issueLookup
+// checks the issues to adopt, which are not checked if nil.
+func (r *GithubIssue) SetupWebhookWithManager(mgr ctrl.Manager, transport http.RoundTripper, hosts []string, issueLookup IssueLookup) error {
 	validator = &GithubIssueValidator{
-		client: mgr.GetClient(),
+		client:      mgr.GetClient(),
+		httpClient:  &http.Client{Transport: transport, Timeout: repoValidationTimeout},
+		hosts:       make(map[string]bool),
+		issueLookup: issueLookup,
+	}
+	for _, host := range hosts {
+		validator.hosts[strings.ToLower(host)] = true
 	}
 
 	return ctrl.NewWebhookManagedBy(mgr).
@@ -73,8 +114,19 @@ func (r *GithubIssue) ValidateCreate() error {
 	if err := r.validateDuplicates(); err != nil {
 		return err
 	}
-
-	return r.validateRepo()
+	if err := r.validateMetadata(); err != nil {
+		return err
+	}
+	if err := r.validateDeletionPolicy(); err != nil {
+		return err
+	}
+	if err := r.validateRepo(); err != nil {
+		return err
+	}
+	if r.Spec.IssueNumber != 0 {
+		return r.validateIssueNumber()
+	}
+	return nil
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
@@ -92,6 +144,31 @@ func (r *GithubIssue) ValidateUpdate(old runtime.Object) error {
 		}
 		errMsg += "could not update: Title field is immutable"
 	}
+	if oldGithubissue.Spec.IssueNumber != 0 && oldGithubissue.Spec.IssueNumber != r.Spec.IssueNumber {
+		if errMsg != "" {
+			errMsg += "\n"
+		}
+		errMsg += "could not update: IssueNumber field is immutable"
+	} else if oldGithubissue.Spec.IssueNumber == 0 && r.Spec.IssueNumber != 0 {
+		if err := r.validateIssueNumber(); err != nil {
+			if errMsg != "" {
+				errMsg += "\n"
+			}
+			errMsg += err.Error()
+		}
+	}
+	if err := r.validateMetadata(); err != nil {
+		if errMsg != "" {
+			errMsg += "\n"
+		}
+		errMsg += err.Error()
+	}
+	if err := r.validateDeletionPolicy(); err != nil {
+		if errMsg != "" {
+			errMsg += "\n"
+		}
+		errMsg += err.Error()
+	}
 
 	if errMsg != "" {
 		return fmt.Errorf(errMsg)
@@ -109,10 +186,121 @@ func (r *GithubIssue) ValidateDelete() error {
 }
 
 func (r *GithubIssue) validateRepo() error {
-	if rsp, err := http.Get(r.Spec.Repo); err != nil || rsp.StatusCode != http.StatusOK {
-		githubissuelog.Info("Repo URL validation", "err", err, "Status", rsp.Status)
+	repoUrl, err := url.Parse(r.Spec.Repo)
+	if err != nil {
+		return fmt.Errorf("Repo %v is not a valid URL", r.Spec.Repo)
+	}
+	if host := strings.ToLower(repoUrl.Host); host != githubHost && !validator.hosts[host] {
+		return fmt.Errorf("Repo %v is on a host not configured in the operator", r.Spec.Repo)
+	}
+
+	rsp, err := validator.httpClient.Get(r.Spec.Repo)
+	if err != nil {
+		githubissuelog.Info("Repo URL validation", "err", err)
 		return fmt.Errorf("Repo %v is unreachable", r.Spec.Repo)
 	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		githubissuelog.Info("Repo URL validation", "Status", rsp.Status)
+		return fmt.Errorf("Repo %v is unreachable", r.Spec.Repo)
+	}
+	return nil
+}
+
+// validateIssueNumber checks that no other resource tracks the issue to adopt already, and that the issue exists
+// in the repository. When the operator credentials can't tell, e.g. the resource uses its own credentials or
+// Github is unreachable, the issue is unverifiable and accepted: the controller reports it if it is missing.
+func (r *GithubIssue) validateIssueNumber() error {
+	var objects GithubIssueList
+	if err := validator.client.List(context.TODO(), &objects, &client.ListOptions{}); err != nil {
+		return err
+	}
+	for _, o := range objects.Items {
+		if o.Namespace == r.Namespace && o.Name == r.Name {
+			continue
+		}
+		if strings.EqualFold(r.Spec.Repo, o.Spec.Repo) &&
+			(r.Spec.IssueNumber == o.Spec.IssueNumber || r.Spec.IssueNumber == o.Status.TrackedIssueId) {
+			return fmt.Errorf("Issue %d is already tracked by %s/%s", r.Spec.IssueNumber, o.Namespace, o.Name)
+		}
+	}
+
+	if validator.issueLookup == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), repoValidationTimeout)
+	defer cancel()
+	exists, err := validator.issueLookup(ctx, r.Spec.Repo, r.Spec.IssueNumber)
+	if err != nil {
+		githubissuelog.Info("Issue validation", "Issue", r.Spec.IssueNumber, "unverifiable", err)
+		return nil
+	}
+	if !exists {
+		if r.Spec.CredentialsSecretRef != nil {
+			githubissuelog.Info("Issue validation", "Issue", r.Spec.IssueNumber,
+				"unverifiable", "not visible with the operator credentials")
+			return nil
+		}
+		return fmt.Errorf("Issue %d does not exist in %v, or is a pull request", r.Spec.IssueNumber, r.Spec.Repo)
+	}
+	return nil
+}
+
+// validateMetadata checks labels, assignees and milestone against the Github limits, and that only a closed
+// state has a reason
+func (r *GithubIssue) validateMetadata() error {
+	var errs []string
+	if len(r.Spec.Labels) > maxLabels {
+		errs = append(errs, fmt.Sprintf("at most %d labels are allowed", maxLabels))
+	}
+	labels := make(map[string]bool)
+	for _, label := range r.Spec.Labels {
+		if strings.TrimSpace(label) == "" || len(label) > maxLabelLength {
+			errs = append(errs, fmt.Sprintf("invalid label '%s': it must have between 1 and %d characters", label, maxLabelLength))
+		}
+		if labels[strings.ToLower(label)] {
+			errs = append(errs, fmt.Sprintf("duplicate label '%s'", label))
+		}
+		labels[strings.ToLower(label)] = true
+	}
+
+	if len(r.Spec.Assignees) > maxAssignees {
+		errs = append(errs, fmt.Sprintf("at most %d assignees are allowed", maxAssignees))
+	}
+	assignees := make(map[string]bool)
+	for _, assignee := range r.Spec.Assignees {
+		if !githubLoginRegexp.MatchString(assignee) || strings.Contains(assignee, "--") {
+			errs = append(errs, fmt.Sprintf("invalid assignee '%s': it is not a Github login", assignee))
+		}
+		if assignees[strings.ToLower(assignee)] {
+			errs = append(errs, fmt.Sprintf("duplicate assignee '%s'", assignee))
+		}
+		assignees[strings.ToLower(assignee)] = true
+	}
+
+	if r.Spec.Milestone != strings.TrimSpace(r.Spec.Milestone) {
+		errs = append(errs, fmt.Sprintf("invalid milestone '%s': it has leading or trailing spaces", r.Spec.Milestone))
+	}
+
+	if r.Spec.StateReason != "" && r.Spec.State != "closed" {
+		errs = append(errs, "invalid stateReason: it requires the closed state")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// validateDeletionPolicy checks that the deletion comment is set if, and only if, the policy adds it
+func (r *GithubIssue) validateDeletionPolicy() error {
+	withComment := r.Spec.DeletionPolicy == DELETION_POLICY_CLOSE_WITH_COMMENT
+	if withComment && strings.TrimSpace(r.Spec.DeletionComment) == "" {
+		return fmt.Errorf("invalid deletionComment: it is required by the %s deletionPolicy", DELETION_POLICY_CLOSE_WITH_COMMENT)
+	}
+	if !withComment && r.Spec.DeletionComment != "" {
+		return fmt.Errorf("invalid deletionComment: it is used only by the %s deletionPolicy", DELETION_POLICY_CLOSE_WITH_COMMENT)
+	}
 	return nil
 }
 
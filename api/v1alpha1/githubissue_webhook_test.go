@@ -2,6 +2,9 @@ package v1alpha1
 
 import (
 	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -17,6 +20,14 @@ var _ = Describe("Githubissues Validation", func() {
 				Expect(err.Error()).To(ContainSubstring("Repo " + ut.Spec.Repo + " is unreachable"))
 			})
 		})
+		When("the repository is on a host not configured in the operator", func() {
+			It("should be rejected", func() {
+				ut := newGithubIssueWithRepo("https://gitlab.com/acme/app")
+				err := ut.ValidateCreate()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("Repo " + ut.Spec.Repo + " is on a host not configured in the operator"))
+			})
+		})
 		When("the repository is reachable", func() {
 			It("should be accepted", func() {
 				ut := newGithubIssue()
@@ -63,6 +74,166 @@ var _ = Describe("Githubissues Validation", func() {
 						"could not update: Title field is immutable"))
 			})
 		})
+
+		When("update the issue number", func() {
+			It("should reject changing it once set", func() {
+				ut := newGithubIssue()
+				ut.Spec.IssueNumber = 5
+
+				utCopy := ut.DeepCopy()
+				utCopy.Spec.IssueNumber = 6
+				err := utCopy.ValidateUpdate(ut)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("could not update: IssueNumber field is immutable"))
+			})
+		})
+
+		When("update labels, assignees or milestone", func() {
+			It("should reject values not accepted by Github", func() {
+				ut := newGithubIssue()
+
+				utCopy := ut.DeepCopy()
+				utCopy.Spec.Labels = []string{"bug", "triage/accepted"}
+				utCopy.Spec.Assignees = []string{"alice", "bob-2"}
+				utCopy.Spec.Milestone = "v1.0"
+				Expect(utCopy.ValidateUpdate(ut)).To(Succeed())
+
+				utCopy.Spec.Labels = []string{"bug", "Bug", ""}
+				utCopy.Spec.Assignees = []string{"-alice", "bob--2"}
+				utCopy.Spec.Milestone = " v1.0"
+				err := utCopy.ValidateUpdate(ut)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal(
+					"duplicate label 'Bug'\n" +
+						"invalid label '': it must have between 1 and 50 characters\n" +
+						"invalid assignee '-alice': it is not a Github login\n" +
+						"invalid assignee 'bob--2': it is not a Github login\n" +
+						"invalid milestone ' v1.0': it has leading or trailing spaces"))
+			})
+		})
+
+		When("update state or stateReason", func() {
+			It("should accept a reason only for the closed state", func() {
+				ut := newGithubIssue()
+
+				utCopy := ut.DeepCopy()
+				utCopy.Spec.State = "closed"
+				utCopy.Spec.StateReason = "not_planned"
+				Expect(utCopy.ValidateUpdate(ut)).To(Succeed())
+
+				utCopy.Spec.State = "open"
+				err := utCopy.ValidateUpdate(ut)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("invalid stateReason: it requires the closed state"))
+			})
+		})
+
+		When("update deletionPolicy or deletionComment", func() {
+			It("should require the comment only for the CloseWithComment policy", func() {
+				ut := newGithubIssue()
+
+				utCopy := ut.DeepCopy()
+				utCopy.Spec.DeletionPolicy = DELETION_POLICY_CLOSE_WITH_COMMENT
+				utCopy.Spec.DeletionComment = "Not tracked anymore"
+				Expect(utCopy.ValidateUpdate(ut)).To(Succeed())
+
+				utCopy.Spec.DeletionPolicy = DELETION_POLICY_LOCK
+				err := utCopy.ValidateUpdate(ut)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("invalid deletionComment: it is used only by the CloseWithComment deletionPolicy"))
+
+				utCopy.Spec.DeletionPolicy = DELETION_POLICY_CLOSE_WITH_COMMENT
+				utCopy.Spec.DeletionComment = ""
+				err = utCopy.ValidateUpdate(ut)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("invalid deletionComment: it is required by the CloseWithComment deletionPolicy"))
+			})
+		})
+	})
+
+	Context("adopting an issue with issueNumber", func() {
+		var lookups []string
+		var exists bool
+		var lookupErr error
+
+		BeforeEach(func() {
+			lookups, exists, lookupErr = nil, true, nil
+			original := validator.issueLookup
+			validator.issueLookup = func(_ context.Context, repo string, number int64) (bool, error) {
+				lookups = append(lookups, fmt.Sprintf("%s#%d", repo, number))
+				return exists, lookupErr
+			}
+			DeferCleanup(func() { validator.issueLookup = original })
+		})
+
+		When("the issue exists", func() {
+			It("should be accepted", func() {
+				ut := newGithubIssue()
+				ut.Spec.IssueNumber = 5
+				Expect(ut.validateIssueNumber()).To(Succeed())
+				Expect(lookups).To(Equal([]string{ut.Spec.Repo + "#5"}))
+			})
+		})
+
+		When("the issue does not exist, or is a pull request", func() {
+			It("should be rejected", func() {
+				exists = false
+				ut := newGithubIssue()
+				ut.Spec.IssueNumber = 5
+				err := ut.validateIssueNumber()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("Issue 5 does not exist in " + ut.Spec.Repo + ", or is a pull request"))
+			})
+		})
+
+		When("the issue is unverifiable with the operator credentials", func() {
+			It("should be accepted", func() {
+				lookupErr = fmt.Errorf("unreachable")
+				ut := newGithubIssue()
+				ut.Spec.IssueNumber = 5
+				Expect(ut.validateIssueNumber()).To(Succeed())
+
+				// the resource uses its own credentials
+				lookupErr = nil
+				exists = false
+				ut.Spec.CredentialsSecretRef = &corev1.LocalObjectReference{Name: "team-a-token"}
+				Expect(ut.validateIssueNumber()).To(Succeed())
+			})
+		})
+
+		When("the issue is already tracked by another CR", func() {
+			It("should be rejected", func() {
+				adopting := newGithubIssue()
+				adopting.Name = "adopting-issue-6"
+				adopting.Spec.Title = "Adopting issue 6"
+				adopting.Spec.IssueNumber = 6
+				Expect(k8sClient.Create(context.Background(), adopting)).To(Succeed())
+
+				tracking := newGithubIssue()
+				tracking.Name = "tracking-issue-7"
+				tracking.Spec.Title = "Tracking issue 7"
+				Expect(k8sClient.Create(context.Background(), tracking)).To(Succeed())
+				tracking.Status.TrackedIssueId = 7
+				Expect(k8sClient.Status().Update(context.Background(), tracking)).To(Succeed())
+
+				ut := newGithubIssue()
+				ut.Name = "githubissues-adopt"
+				ut.Spec.Title = "Another title"
+				ut.Spec.IssueNumber = 6
+				err := ut.validateIssueNumber()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("Issue 6 is already tracked by default/adopting-issue-6"))
+
+				ut.Spec.IssueNumber = 7
+				err = ut.validateIssueNumber()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("Issue 7 is already tracked by default/tracking-issue-7"))
+
+				// the issues of other repositories can have the same number
+				ut.Spec.Repo = "https://github.com/clobrano/another-repo"
+				Expect(ut.validateIssueNumber()).To(Succeed())
+			})
+		})
 	})
 })
 
@@ -99,7 +99,7 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&GithubIssue{}).SetupWebhookWithManager(mgr)
+	err = (&GithubIssue{}).SetupWebhookWithManager(mgr, nil, nil, nil)
 	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:webhook
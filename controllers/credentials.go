@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	trainingv1alpha1 "github.com/clobrano/githubissues-operator/api/v1alpha1"
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+)
+
+// CredentialsSecretTokenKey is the key of the Github token in the Secret referenced by Spec.CredentialsSecretRef
+const CredentialsSecretTokenKey = "token"
+
+// credentialsError is returned when the Secret referenced by a GithubIssue can't be used.
+// Reason is used for the Synchronized condition
+type credentialsError struct {
+	Reason string
+	Err    error
+}
+
+func (e *credentialsError) Error() string {
+	return e.Err.Error()
+}
+
+// credentialedClient is a Github client built for the token of a Secret. The fingerprint of the token is used
+// to detect token changes.
+type credentialedClient struct {
+	fingerprint [sha256.Size]byte
+	client      gclient.GithubClient
+}
+
+// getRepoClient returns the Github client to use for the GithubIssue. The client is built with the token of the
+// Secret referenced by Spec.CredentialsSecretRef, and reused until the token changes. Without a reference, the
+// operator client is used.
+func (r *GithubIssueReconciler) getRepoClient(ctx context.Context, gi *trainingv1alpha1.GithubIssue) (gclient.GithubClient, error) {
+	if gi.Spec.CredentialsSecretRef == nil {
+		return r.RepoClient, nil
+	}
+
+	key := types.NamespacedName{Namespace: gi.Namespace, Name: gi.Spec.CredentialsSecretRef.Name}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, key, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.forgetRepoClient(key)
+			return nil, &credentialsError{
+				Reason: "CredentialsSecretNotFound",
+				Err:    fmt.Errorf("credentials Secret %s not found", key),
+			}
+		}
+		return nil, err
+	}
+
+	// the token is often written with a trailing newline, e.g. by "kubectl create secret --from-file"
+	token := strings.TrimSpace(string(secret.Data[CredentialsSecretTokenKey]))
+	if token == "" {
+		r.forgetRepoClient(key)
+		return nil, &credentialsError{
+			Reason: "CredentialsSecretInvalid",
+			Err:    fmt.Errorf("credentials Secret %s has no token in the %q key", key, CredentialsSecretTokenKey),
+		}
+	}
+
+	fingerprint := sha256.Sum256([]byte(token))
+	r.repoClientsMu.Lock()
+	defer r.repoClientsMu.Unlock()
+	if c, ok := r.repoClients[key]; ok && c.fingerprint == fingerprint {
+		return c.client, nil
+	}
+	if r.repoClients == nil {
+		r.repoClients = make(map[types.NamespacedName]credentialedClient)
+	}
+	c := credentialedClient{
+		fingerprint: fingerprint,
+		client:      r.NewRepoClient(gclient.StaticToken(token)),
+	}
+	r.repoClients[key] = c
+	return c.client, nil
+}
+
+func (r *GithubIssueReconciler) forgetRepoClient(key types.NamespacedName) {
+	r.repoClientsMu.Lock()
+	defer r.repoClientsMu.Unlock()
+	delete(r.repoClients, key)
+}
+
+// handleCredentialsError reports the unusable credentials in the Synchronized condition. The GithubIssue is
+// reconciled again when the Secret changes.
+func (r *GithubIssueReconciler) handleCredentialsError(gi *trainingv1alpha1.GithubIssue, err error) (ctrl.Result, error) {
+	var credErr *credentialsError
+	if !errors.As(err, &credErr) {
+		return ctrl.Result{}, err
+	}
+	meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
+		Type:    "Synchronized",
+		Status:  metav1.ConditionFalse,
+		Reason:  credErr.Reason,
+		Message: credErr.Error(),
+	})
+	return ctrl.Result{}, nil
+}
+
+// findGithubIssuesForSecret returns the requests for the GithubIssues referencing the Secret. Only the metadata
+// of the Secrets is watched.
+func (r *GithubIssueReconciler) findGithubIssuesForSecret(secret client.Object) []reconcile.Request {
+	var githubIssues trainingv1alpha1.GithubIssueList
+	if err := r.List(context.Background(), &githubIssues, client.InNamespace(secret.GetNamespace())); err != nil {
+		log.Log.Error(err, "could not list GithubIssues referencing Secret", "Secret", client.ObjectKeyFromObject(secret))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, gi := range githubIssues.Items {
+		if gi.Spec.CredentialsSecretRef != nil && gi.Spec.CredentialsSecretRef.Name == secret.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gi)})
+		}
+	}
+	return requests
+}
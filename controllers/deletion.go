@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	trainingv1alpha1 "github.com/clobrano/githubissues-operator/api/v1alpha1"
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+)
+
+// deletionRetryMinDelay and deletionRetryMaxDelay bound the exponential backoff between the attempts to apply
+// the DeletionPolicy
+const (
+	deletionRetryMinDelay = 5 * time.Second
+	deletionRetryMaxDelay = 10 * time.Minute
+)
+
+// reconcileDeletion applies the DeletionPolicy of the deleted resource and then removes its finalizer. The steps
+// completed are recorded in Status.DeletionPhase, so that a failed attempt is retried with exponential backoff
+// without repeating them, while the DeletionBlocked condition reports why the resource cannot be removed yet.
+// The finalizer is removed without applying the policy if the resource has the ForceDeletionAnnotation, or
+// after the DeletionTimeout.
+func (r *GithubIssueReconciler) reconcileDeletion(ctx context.Context, gi *trainingv1alpha1.GithubIssue) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(gi, GIFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if gi.Annotations[ForceDeletionAnnotation] == "true" {
+		l.Info("Removing the finalizer without applying the deletion policy", "Annotation", ForceDeletionAnnotation)
+		return r.removeFinalizer(ctx, gi)
+	}
+	remaining := time.Duration(0)
+	if r.DeletionTimeout > 0 {
+		remaining = r.DeletionTimeout - time.Since(gi.DeletionTimestamp.Time)
+		if remaining <= 0 {
+			l.Info("Removing the finalizer without applying the deletion policy", "Timeout", r.DeletionTimeout,
+				"Phase", gi.Status.DeletionPhase)
+			return r.removeFinalizer(ctx, gi)
+		}
+	}
+
+	if err := r.finalizeTicket(ctx, gi); err != nil {
+		l.Error(err, "could not apply the deletion policy", "Policy", gi.Spec.DeletionPolicy, "Phase", gi.Status.DeletionPhase)
+		result := handleDeletionError(gi, err)
+		if remaining > 0 && remaining < result.RequeueAfter {
+			result.RequeueAfter = remaining
+		}
+		return result, nil
+	}
+	return r.removeFinalizer(ctx, gi)
+}
+
+func (r *GithubIssueReconciler) removeFinalizer(ctx context.Context, gi *trainingv1alpha1.GithubIssue) (ctrl.Result, error) {
+	controllerutil.RemoveFinalizer(gi, GIFinalizer)
+	if err := r.Update(ctx, gi); err != nil {
+		// the status patch is skipped without the finalizer, while the resource still exists
+		controllerutil.AddFinalizer(gi, GIFinalizer)
+		return ctrl.Result{}, fmt.Errorf("could not remove finalizer: %v", err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// finalizeTicket applies the DeletionPolicy to the tracked issue, if any, from the step after
+// Status.DeletionPhase. The Orphan policy does not need Github at all.
+func (r *GithubIssueReconciler) finalizeTicket(ctx context.Context, gi *trainingv1alpha1.GithubIssue) error {
+	if gi.Spec.DeletionPolicy == trainingv1alpha1.DELETION_POLICY_ORPHAN ||
+		gi.Status.DeletionPhase == trainingv1alpha1.DELETION_PHASE_COMPLETED {
+		return nil
+	}
+
+	repoClient, err := r.getRepoClient(ctx, gi)
+	if err != nil {
+		return err
+	}
+	target, err := r.getTarget(ctx, repoClient, gi)
+	if err != nil {
+		return fmt.Errorf("could not get matching ticket: %w", err)
+	}
+	if target != nil {
+		if err := applyDeletionPolicy(ctx, repoClient, gi, *target); err != nil {
+			return err
+		}
+	}
+	gi.Status.DeletionPhase = trainingv1alpha1.DELETION_PHASE_COMPLETED
+	return nil
+}
+
+// applyDeletionPolicy applies the DeletionPolicy to the ticket. Only an open issue is closed, and commented,
+// while a closed issue is locked as well by the Lock policy.
+func applyDeletionPolicy(ctx context.Context, repoClient gclient.GithubClient, gi *trainingv1alpha1.GithubIssue, ticket gclient.GithubTicket) error {
+	policy := gi.Spec.DeletionPolicy
+	if ticket.State == "open" {
+		if policy == trainingv1alpha1.DELETION_POLICY_CLOSE_WITH_COMMENT && gi.Status.DeletionPhase == "" {
+			// the comment is added first, so that it explains the closing in the issue timeline
+			if err := repoClient.CommentTicket(ctx, ticket, gi.Spec.DeletionComment); err != nil {
+				return fmt.Errorf("could not comment ticket: %w", err)
+			}
+			gi.Status.DeletionPhase = trainingv1alpha1.DELETION_PHASE_COMMENTED
+		}
+
+		closed := ticket
+		closed.State = "closed"
+		if policy == trainingv1alpha1.DELETION_POLICY_CLOSE_AS_NOT_PLANNED {
+			closed.StateReason = "not_planned"
+		}
+		if err := repoClient.UpdateTicket(ctx, ticket, closed); err != nil {
+			return fmt.Errorf("could not close ticket: %w", err)
+		}
+	}
+	gi.Status.DeletionPhase = trainingv1alpha1.DELETION_PHASE_CLOSED
+
+	if policy == trainingv1alpha1.DELETION_POLICY_LOCK && !ticket.Locked {
+		if err := repoClient.LockTicket(ctx, ticket); err != nil {
+			return fmt.Errorf("could not lock ticket: %w", err)
+		}
+	}
+	return nil
+}
+
+// handleDeletionError reports the failed attempt to apply the DeletionPolicy in the DeletionBlocked condition,
+// and requeues the resource after an exponential backoff, or when the rate limit is reset.
+func handleDeletionError(gi *trainingv1alpha1.GithubIssue, err error) ctrl.Result {
+	gi.Status.DeletionAttempts++
+	delay := deletionRetryDelay(gi.Status.DeletionAttempts)
+
+	var reason string
+	var credErr *credentialsError
+	var rateLimited *gclient.RateLimitedError
+	switch {
+	case errors.As(err, &credErr):
+		reason = credErr.Reason
+	case errors.As(err, &rateLimited):
+		reason = "RateLimited"
+		if untilReset := time.Until(rateLimited.Reset); untilReset > delay {
+			delay = untilReset
+		}
+	default:
+		reason, _ = githubErrorReason(err)
+	}
+	meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
+		Type:    "DeletionBlocked",
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: fmt.Sprintf("%v (attempt %d, add the %s=true annotation to remove the resource anyway)", err, gi.Status.DeletionAttempts, ForceDeletionAnnotation),
+	})
+	return ctrl.Result{RequeueAfter: delay}
+}
+
+// deletionRetryDelay returns the delay before the next attempt, doubling from deletionRetryMinDelay up to
+// deletionRetryMaxDelay
+func deletionRetryDelay(attempts int32) time.Duration {
+	delay := deletionRetryMinDelay
+	for i := int32(1); i < attempts && delay < deletionRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > deletionRetryMaxDelay {
+		delay = deletionRetryMaxDelay
+	}
+	return delay
+}
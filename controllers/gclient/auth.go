@@ -0,0 +1,263 @@
+package gclient
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TokenSource provides the tokens used to authenticate the requests to Github
+type TokenSource interface {
+	// Token returns the token for the requests about the repository, identified by its web or API URL
+	Token(ctx context.Context, repo string) (string, error)
+}
+
+// EnvToken reads the token from the GITHUB_TOKEN environment variable
+type EnvToken struct{}
+
+func (EnvToken) Token(_ context.Context, repo string) (string, error) {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return "", fmt.Errorf("could not get github token for '%s': %w", repo, ErrUnauthorized)
+	}
+	return token, nil
+}
+
+// StaticToken is a token that never changes, e.g. read from a Secret
+type StaticToken string
+
+func (t StaticToken) Token(_ context.Context, repo string) (string, error) {
+	if t == "" {
+		return "", fmt.Errorf("could not get github token for '%s': %w", repo, ErrUnauthorized)
+	}
+	return string(t), nil
+}
+
+// HostTokens provides the token of the host of each repository, github.com or one of Hosts, so that the token
+// of a host is never sent to another one
+type HostTokens struct {
+	Hosts Hosts
+	// Tokens maps the web hosts to their tokens
+	Tokens map[string]TokenSource
+}
+
+func (h *HostTokens) Token(ctx context.Context, repo string) (string, error) {
+	host, ok := h.Hosts.webHost(repo)
+	if !ok || h.Tokens[host] == nil {
+		return "", fmt.Errorf("no github token configured for the host of '%s': %w", repo, ErrUnauthorized)
+	}
+	return h.Tokens[host].Token(ctx, repo)
+}
+
+// observeExpiration forwards the expiration to the token source of the host of the repository
+func (h *HostTokens) observeExpiration(repo, token string, expiration time.Time) {
+	host, ok := h.Hosts.webHost(repo)
+	if !ok {
+		return
+	}
+	if observer, ok := h.Tokens[host].(tokenExpirationObserver); ok {
+		observer.observeExpiration(repo, token, expiration)
+	}
+}
+
+// appJWTLifetime is the lifetime of the JWT authenticating the Github App (Github accepts at most 10 minutes)
+const appJWTLifetime time.Duration = 9 * time.Minute
+
+// installationTokenMargin is how long before its expiration an installation token is renewed
+const installationTokenMargin time.Duration = time.Minute
+
+// AppTokenSource authenticates as a Github App installation. It signs a JWT with the App private key, finds
+// the installation of the App on the owner of each repository, and exchanges the JWT for an installation token,
+// which is cached until its expiration. The installations and their tokens are kept apart for each host.
+type AppTokenSource struct {
+	// APIURL is the root of the Github API of github.com
+	APIURL string
+	// Hosts maps the other hosts where the App is registered, with the same ID and key, to the root of their API
+	Hosts      Hosts
+	AppID      int64
+	PrivateKey *rsa.PrivateKey
+	// Transport sends the requests to Github. http.DefaultTransport is used if not set
+	Transport http.RoundTripper
+	// Timeout is the deadline of each request sent to Github, DEFAULT_REQUEST_TIMEOUT if not set
+	Timeout time.Duration
+
+	mu sync.Mutex
+	// installations maps the repository owners, as "host/owner", to the App installation IDs
+	installations map[string]int64
+	// tokens maps the installations, as "host/ID", to their tokens
+	tokens map[string]installationToken
+}
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LoadAppPrivateKey reads the PEM encoded private key of a Github App
+func LoadAppPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read Github App private key: %v", err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Github App private key: %v", err)
+	}
+	return key, nil
+}
+
+func (a *AppTokenSource) Token(ctx context.Context, repo string) (string, error) {
+	host, ok := a.Hosts.webHost(repo)
+	if !ok {
+		return "", fmt.Errorf("Github App %d is not configured for the host of '%s': %w", a.AppID, repo, ErrUnauthorized)
+	}
+	apiURL := a.APIURL
+	if host != GITHUB_HOST {
+		apiURL, _ = a.Hosts.apiURL(host)
+	}
+	repoPath := repositoryPath(repo)
+	owner := host + "/" + strings.Split(repoPath, "/")[0]
+
+	installationID, cached, err := a.installationID(ctx, apiURL, owner, repoPath)
+	if err != nil {
+		return "", err
+	}
+
+	tokenKey := fmt.Sprintf("%s/%d", host, installationID)
+	a.mu.Lock()
+	token, ok := a.tokens[tokenKey]
+	a.mu.Unlock()
+	if ok && time.Until(token.ExpiresAt) > installationTokenMargin {
+		return token.Token, nil
+	}
+
+	token, err = a.createInstallationToken(ctx, apiURL, installationID)
+	if cached && (errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnauthorized)) {
+		// the App was uninstalled, and maybe installed again with another ID
+		a.mu.Lock()
+		delete(a.installations, owner)
+		a.mu.Unlock()
+		installationID, _, err = a.installationID(ctx, apiURL, owner, repoPath)
+		if err != nil {
+			return "", err
+		}
+		tokenKey = fmt.Sprintf("%s/%d", host, installationID)
+		token, err = a.createInstallationToken(ctx, apiURL, installationID)
+	}
+	if err != nil {
+		return "", err
+	}
+	a.mu.Lock()
+	if a.tokens == nil {
+		a.tokens = make(map[string]installationToken)
+	}
+	a.tokens[tokenKey] = token
+	a.mu.Unlock()
+	return token.Token, nil
+}
+
+// installationID returns the ID of the App installation on the owner ("host/owner") of the repository, and whether
+// it was already known
+func (a *AppTokenSource) installationID(ctx context.Context, apiURL, owner, repoPath string) (int64, bool, error) {
+	a.mu.Lock()
+	installationID, ok := a.installations[owner]
+	a.mu.Unlock()
+	if ok {
+		return installationID, true, nil
+	}
+
+	installationID, err := a.getInstallationID(ctx, apiURL, repoPath)
+	if err != nil {
+		return 0, false, err
+	}
+	a.mu.Lock()
+	if a.installations == nil {
+		a.installations = make(map[string]int64)
+	}
+	a.installations[owner] = installationID
+	a.mu.Unlock()
+	return installationID, false, nil
+}
+
+// getInstallationID returns the ID of the App installation that can access the repository ("owner/repository")
+func (a *AppTokenSource) getInstallationID(ctx context.Context, apiURL, repo string) (int64, error) {
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	requestUrl := fmt.Sprintf("%s/repos/%s/installation", apiURL, repo)
+	if err := a.sendAppRequest(ctx, "GET", requestUrl, http.StatusOK, &installation); err != nil {
+		return 0, fmt.Errorf("could not find the installation of Github App %d for %s: %w", a.AppID, repo, err)
+	}
+	return installation.ID, nil
+}
+
+func (a *AppTokenSource) createInstallationToken(ctx context.Context, apiURL string, installationID int64) (installationToken, error) {
+	var token installationToken
+	requestUrl := fmt.Sprintf("%s/app/installations/%d/access_tokens", apiURL, installationID)
+	if err := a.sendAppRequest(ctx, "POST", requestUrl, http.StatusCreated, &token); err != nil {
+		return installationToken{}, fmt.Errorf("could not create a token for installation %d of Github App %d: %w",
+			installationID, a.AppID, err)
+	}
+	return token, nil
+}
+
+// sendAppRequest sends a request authenticated as the App, and decodes the response in out
+func (a *AppTokenSource) sendAppRequest(ctx context.Context, method, requestUrl string, wantStatus int, out interface{}) error {
+	signed, err := a.signJWT()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+signed)
+	res, err := (&http.Client{Transport: a.Transport}).Do(req)
+	if err != nil {
+		return newTransportError(requestUrl, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != wantStatus {
+		return newResponseError(requestUrl, res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("can't decode body: %v", err)
+	}
+	return nil
+}
+
+func (a *AppTokenSource) timeout() time.Duration {
+	if a.Timeout <= 0 {
+		return DEFAULT_REQUEST_TIMEOUT
+	}
+	return a.Timeout
+}
+
+func (a *AppTokenSource) signJWT() (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		// allow for clock drift
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(appJWTLifetime)),
+		Issuer:    strconv.FormatInt(a.AppID, 10),
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(a.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("could not sign Github App JWT: %v", err)
+	}
+	return signed, nil
+}
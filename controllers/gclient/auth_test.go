@@ -0,0 +1,159 @@
+package gclient_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github App authentication", func() {
+	var (
+		ts                 *httptest.Server
+		key                *rsa.PrivateKey
+		tokensCreated      int
+		issuesAuthorizedBy []string
+		// installationID is the ID of the App installation on owner, which changes when the App is reinstalled
+		installationID int64
+		tokenLifetime  time.Duration
+	)
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+		tokensCreated = 0
+		issuesAuthorizedBy = nil
+		installationID = 7
+		tokenLifetime = time.Hour
+
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			// the Github Enterprise Server API is served under /api/v3
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, gclient.GHES_API_PATH)
+			switch {
+			case r.URL.Path == "/repos/owner/repo/installation" || r.URL.Path == fmt.Sprintf("/app/installations/%d/access_tokens", installationID):
+				// the App endpoints require a JWT signed by the App
+				claims := jwt.RegisteredClaims{}
+				_, err := jwt.ParseWithClaims(auth, &claims, func(*jwt.Token) (interface{}, error) {
+					return &key.PublicKey, nil
+				})
+				if err != nil || claims.Issuer != "42" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if r.URL.Path == "/repos/owner/repo/installation" {
+					w.WriteHeader(http.StatusOK)
+					fmt.Fprintf(w, `{"id": %d}`, installationID)
+					return
+				}
+				tokensCreated++
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"token": "installation token %d", "expires_at": "%s"}`,
+					tokensCreated, time.Now().Add(tokenLifetime).Format(time.RFC3339))
+			case strings.HasPrefix(r.URL.Path, "/app/installations/"):
+				// the installation was deleted
+				w.WriteHeader(http.StatusNotFound)
+			case r.URL.Path == "/repos/unknown/repo/installation":
+				w.WriteHeader(http.StatusNotFound)
+			case r.URL.Path == "/repos/slow/repo/installation":
+				// Github does not answer until the request is canceled
+				<-r.Context().Done()
+			case strings.HasSuffix(r.URL.Path, "/issues"):
+				issuesAuthorizedBy = append(issuesAuthorizedBy, auth)
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `[]`)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("uses the installation token until it expires", func() {
+		credentials := &gclient.AppTokenSource{APIURL: ts.URL, AppID: 42, PrivateKey: key}
+		underTest := gclient.GClient{BaseURL: ts.URL + "/repos", Credentials: credentials, CacheSize: -1}
+
+		for i := 0; i < 2; i++ {
+			_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(tokensCreated).To(Equal(1))
+		Expect(issuesAuthorizedBy).To(Equal([]string{"installation token 1", "installation token 1"}))
+	})
+
+	It("keeps the installation tokens of each host apart", func() {
+		credentials := &gclient.AppTokenSource{APIURL: ts.URL, AppID: 42, PrivateKey: key,
+			Hosts: gclient.Hosts{"github.example.com": ts.URL + gclient.GHES_API_PATH}}
+
+		githubToken, err := credentials.Token(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		ghesToken, err := credentials.Token(context.TODO(), "https://github.example.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(githubToken).To(Equal("installation token 1"))
+		Expect(ghesToken).To(Equal("installation token 2"))
+
+		_, err = credentials.Token(context.TODO(), "https://gitlab.com/owner/repo")
+		Expect(err).To(MatchError(gclient.ErrUnauthorized))
+	})
+
+	It("looks up the installation again after the App is reinstalled", func() {
+		// the tokens are renewed at every request
+		tokenLifetime = 30 * time.Second
+		credentials := &gclient.AppTokenSource{APIURL: ts.URL, AppID: 42, PrivateKey: key}
+		_, err := credentials.Token(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+
+		installationID = 8
+		token, err := credentials.Token(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal("installation token 2"))
+	})
+
+	It("fails if the App is not installed for the repository owner", func() {
+		credentials := &gclient.AppTokenSource{APIURL: ts.URL, AppID: 42, PrivateKey: key}
+		_, err := credentials.Token(context.TODO(), "https://github.com/unknown/repo")
+		Expect(err).To(MatchError(gclient.ErrNotFound))
+	})
+
+	It("gives up the App requests after the timeout", func() {
+		credentials := &gclient.AppTokenSource{APIURL: ts.URL, AppID: 42, PrivateKey: key, Timeout: 100 * time.Millisecond}
+		_, err := credentials.Token(context.TODO(), "https://github.com/slow/repo")
+		Expect(err).To(MatchError(gclient.ErrTransient))
+	})
+})
+
+var _ = Describe("Github host tokens", func() {
+	It("sends each token only to its host", func() {
+		underTest := &gclient.HostTokens{
+			Hosts: gclient.Hosts{"github.example.com": "https://api.github.example.com"},
+			Tokens: map[string]gclient.TokenSource{
+				gclient.GITHUB_HOST:  gclient.StaticToken("github token"),
+				"github.example.com": gclient.StaticToken("ghes token"),
+			},
+		}
+
+		Expect(underTest.Token(context.TODO(), "https://github.com/owner/repo")).To(Equal("github token"))
+		Expect(underTest.Token(context.TODO(), "https://api.github.com/repos/owner/repo")).To(Equal("github token"))
+		Expect(underTest.Token(context.TODO(), "https://github.example.com/owner/repo")).To(Equal("ghes token"))
+		Expect(underTest.Token(context.TODO(), "https://api.github.example.com/repos/owner/repo")).To(Equal("ghes token"))
+
+		delete(underTest.Tokens, "github.example.com")
+		_, err := underTest.Token(context.TODO(), "https://github.example.com/owner/repo")
+		Expect(err).To(MatchError(gclient.ErrUnauthorized))
+		_, err = underTest.Token(context.TODO(), "https://gitlab.com/owner/repo")
+		Expect(err).To(MatchError(gclient.ErrUnauthorized))
+	})
+})
@@ -0,0 +1,128 @@
+package gclient
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// DEFAULT_CACHE_SIZE is the memory, in bytes, used to cache responses when GClient.CacheSize is not set
+const DEFAULT_CACHE_SIZE int64 = 16 * 1024 * 1024
+
+type cachedResponse struct {
+	url    string
+	header http.Header
+	body   []byte
+}
+
+func (c *cachedResponse) size() int64 {
+	return int64(len(c.url) + len(c.body))
+}
+
+// responseCache stores the GET responses that have an ETag or a Last-Modified header, so that requests can be
+// made conditional. Github does not count 304 Not Modified responses against the rate limit.
+// The least recently used responses are evicted when the cache exceeds its size.
+type responseCache struct {
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// addConditionalHeaders makes the request conditional if a response for the same URL is cached
+func (c *responseCache) addConditionalHeaders(req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[req.URL.String()]
+	if !ok {
+		return
+	}
+	cached := e.Value.(*cachedResponse)
+	if etag := cached.header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := cached.header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// resolve returns the cached response if res is 304 Not Modified, and caches res if it can be used for
+// conditional requests. maxSize is the memory, in bytes, the cache can use.
+func (c *responseCache) resolve(req *http.Request, res *http.Response, maxSize int64) (*http.Response, error) {
+	url := req.URL.String()
+
+	if res.StatusCode == http.StatusNotModified {
+		c.mu.Lock()
+		e, ok := c.entries[url]
+		if ok {
+			c.lru.MoveToFront(e)
+		}
+		c.mu.Unlock()
+		if !ok {
+			return res, nil
+		}
+		res.Body.Close()
+		cached := e.Value.(*cachedResponse)
+		header := cached.header.Clone()
+		// keep the up to date rate limit information
+		for _, h := range []string{"X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+			if v := res.Header.Get(h); v != "" {
+				header.Set(h, v)
+			}
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         res.Proto,
+			ProtoMajor:    res.ProtoMajor,
+			ProtoMinor:    res.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.body)),
+			ContentLength: int64(len(cached.body)),
+			Request:       req,
+		}, nil
+	}
+
+	if res.StatusCode != http.StatusOK || (res.Header.Get("ETag") == "" && res.Header.Get("Last-Modified") == "") {
+		return res, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	c.store(&cachedResponse{url: url, header: res.Header.Clone(), body: body}, maxSize)
+	return res, nil
+}
+
+func (c *responseCache) store(cached *cachedResponse, maxSize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+	if e, ok := c.entries[cached.url]; ok {
+		c.remove(e)
+	}
+	if cached.size() > maxSize {
+		return
+	}
+	c.entries[cached.url] = c.lru.PushFront(cached)
+	c.size += cached.size()
+	for c.size > maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(e *list.Element) {
+	cached := c.lru.Remove(e).(*cachedResponse)
+	delete(c.entries, cached.url)
+	c.size -= cached.size()
+}
@@ -0,0 +1,88 @@
+package gclient_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github client cache", func() {
+	var (
+		ts          *httptest.Server
+		notModified int
+		conditional []string
+	)
+
+	pages := map[string]string{
+		"":  `[{"number": 1, "title": "issue 1 title", "body": "issue 1 description", "state": "open"}]`,
+		"2": `[{"number": 2, "title": "issue 2 title", "body": "issue 2 description", "state": "closed"}]`,
+	}
+
+	BeforeEach(func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		notModified = 0
+		conditional = nil
+
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			page := r.URL.Query().Get("page")
+			etag := fmt.Sprintf(`"etag-page-%s"`, page)
+			if inm := r.Header.Get("If-None-Match"); inm != "" {
+				conditional = append(conditional, inm)
+				if inm == etag {
+					notModified++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+			if page == "" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?state=all&page=2>; rel="next"`, ts.URL, r.URL.Path))
+			}
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, pages[page])
+		}))
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("serves not modified pages from the cache", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		first, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(first).To(HaveLen(2))
+		Expect(notModified).To(Equal(0))
+
+		second, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(second).To(ConsistOf(first))
+		Expect(notModified).To(Equal(2))
+		Expect(conditional).To(ConsistOf(`"etag-page-"`, `"etag-page-2"`))
+	})
+
+	It("does not cache responses bigger than the cache size", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: 10}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(conditional).To(BeEmpty())
+	})
+
+	It("can be disabled", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(conditional).To(BeEmpty())
+	})
+})
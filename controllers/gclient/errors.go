@@ -0,0 +1,97 @@
+package gclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Kinds of the errors returned by GithubClient, to be checked with errors.Is
+var (
+	// ErrUnauthorized means that Github did not accept the token (401)
+	ErrUnauthorized = errors.New("authentication failed")
+	// ErrForbidden means that the token has no permission for the request (403)
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound means that the repository, or the issue, does not exist or is not visible with the token (404)
+	ErrNotFound = errors.New("not found")
+	// ErrValidation means that Github refused the content of the request (422)
+	ErrValidation = errors.New("validation failed")
+	// ErrTransient means that the request could succeed if retried later (5xx, network errors, timeouts)
+	ErrTransient = errors.New("transient error")
+)
+
+// RequestError describes a failed request to Github
+type RequestError struct {
+	URL string
+	// StatusCode is the code of the Github response, or 0 if no response was received
+	StatusCode int
+	// Message is the error message returned by Github
+	Message string
+	// Kind is one of the ErrXXX errors
+	Kind error
+	// Err is the cause of the failure when no response was received
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("issues request to %s failed: %v", e.URL, e.Err)
+	}
+	msg := fmt.Sprintf("request %s returned with wrong code: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func (e *RequestError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// AssigneesDroppedError is returned when Github did not assign some of the requested assignees to the issue,
+// which it does silently for the users that cannot be assigned to the issues of the repository
+type AssigneesDroppedError struct {
+	URL       string
+	Assignees []string
+}
+
+func (e *AssigneesDroppedError) Error() string {
+	return fmt.Sprintf("request %s did not assign %s, they cannot be assigned to the issues of the repository",
+		e.URL, strings.Join(e.Assignees, ", "))
+}
+
+// newResponseError returns the error describing an unexpected response
+func newResponseError(url string, res *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	// the message is optional, ignore decoding errors
+	_ = json.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&body)
+
+	var kind error
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		kind = ErrUnauthorized
+	case res.StatusCode == http.StatusForbidden:
+		kind = ErrForbidden
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		kind = ErrNotFound
+	case res.StatusCode == http.StatusUnprocessableEntity:
+		kind = ErrValidation
+	case res.StatusCode >= http.StatusInternalServerError:
+		kind = ErrTransient
+	}
+	return &RequestError{URL: url, StatusCode: res.StatusCode, Message: body.Message, Kind: kind}
+}
+
+// newTransportError returns the error describing a request that received no response
+func newTransportError(url string, err error) error {
+	return &RequestError{URL: url, Kind: ErrTransient, Err: err}
+}
@@ -2,16 +2,30 @@ package gclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"regexp"
-	"strconv"
+	"strings"
+	"time"
 )
 
-const GITHUB_API_BASE_URL string = "https://api.github.com/repos"
+// GITHUB_API_URL is the root of the Github API
+const GITHUB_API_URL string = "https://api.github.com"
+
+const GITHUB_API_BASE_URL string = GITHUB_API_URL + "/repos"
+
+// DEFAULT_PER_PAGE is the page size used when GClient.PerPage is not set (100 is the maximum allowed by Github)
+const DEFAULT_PER_PAGE int = 100
+
+// DEFAULT_MAX_PAGES is the page cap used when GClient.MaxPages is not set
+const DEFAULT_MAX_PAGES int = 50
+
+// DEFAULT_REQUEST_TIMEOUT is the deadline of each request when GClient.Timeout is not set
+const DEFAULT_REQUEST_TIMEOUT time.Duration = 30 * time.Second
 
 type GithubTicket struct {
 	Number        int64  `json:"number"`
@@ -19,7 +33,17 @@ type GithubTicket struct {
 	Body          string `json:"body"`
 	State         string `json:"state"`
 	RepositoryURL string `json:"repository_url"`
-	HasPr         bool   `json:"has_pr"`
+	HTMLURL       string `json:"html_url"`
+	// StateReason is why the issue was closed (completed or not_planned), or reopened
+	StateReason string `json:"state_reason"`
+	// Labels are the names of the labels of the issue
+	Labels []string `json:"labels"`
+	// Assignees are the logins of the users assigned to the issue
+	Assignees []string `json:"assignees"`
+	// Milestone is the title of the milestone of the issue, or empty if there is none
+	Milestone string `json:"milestone"`
+	// Locked is true if the conversation of the issue is locked
+	Locked bool `json:"locked"`
 }
 
 type githubIssue struct {
@@ -27,67 +51,144 @@ type githubIssue struct {
 	Title         string            `json:"title"`
 	Body          string            `json:"body"`
 	State         string            `json:"state"`
+	StateReason   string            `json:"state_reason"`
 	RepositoryURL string            `json:"repository_url"`
+	HTMLURL       string            `json:"html_url"`
+	UpdatedAt     time.Time         `json:"updated_at"`
 	PullRequest   map[string]string `json:"pull_request"`
+	Labels        []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Assignees []struct {
+		Login string `json:"login"`
+	} `json:"assignees"`
+	Milestone *githubMilestone `json:"milestone"`
+	Locked    bool             `json:"locked"`
+}
+
+type githubMilestone struct {
+	Number int64  `json:"number"`
+	Title  string `json:"title"`
+}
+
+func (i githubIssue) toTicket() GithubTicket {
+	var labels, assignees []string
+	for _, l := range i.Labels {
+		labels = append(labels, l.Name)
+	}
+	for _, a := range i.Assignees {
+		assignees = append(assignees, a.Login)
+	}
+	milestone := ""
+	if i.Milestone != nil {
+		milestone = i.Milestone.Title
+	}
+	return GithubTicket{
+		Number:        i.Number,
+		Title:         i.Title,
+		Body:          i.Body,
+		RepositoryURL: i.RepositoryURL,
+		State:         i.State,
+		HTMLURL:       i.HTMLURL,
+		StateReason:   i.StateReason,
+		Labels:        labels,
+		Assignees:     assignees,
+		Milestone:     milestone,
+		Locked:        i.Locked,
+	}
 }
 
 type GithubClient interface {
-	GetTickets(string) ([]GithubTicket, error)
-	CreateTicket(GithubTicket) error
-	UpdateTicket(GithubTicket) error
-	IssueHasPR(GithubTicket) bool
+	GetTickets(context.Context, string) ([]GithubTicket, error)
+	GetTicket(context.Context, string, int64) (*GithubTicket, error)
+	CreateTicket(context.Context, GithubTicket) (*GithubTicket, error)
+	// UpdateTicket applies to the issue the changes from the current ticket to the desired one
+	UpdateTicket(context.Context, GithubTicket, GithubTicket) error
+	// CommentTicket adds a comment with the given body to the issue
+	CommentTicket(context.Context, GithubTicket, string) error
+	// LockTicket locks the conversation of the issue
+	LockTicket(context.Context, GithubTicket) error
+	// GetLinkedPRs returns the PRs linked to the issue according to its timeline and its Development section
+	GetLinkedPRs(context.Context, string, int64) ([]LinkedPR, error)
 }
 
 type GClient struct {
+	// BaseURL is the root of the repositories API of github.com (GITHUB_API_BASE_URL), used for the
+	// repositories on github.com
 	BaseURL string
+	// Hosts maps the web hosts of the repositories not on github.com, e.g. Github Enterprise Server instances,
+	// to the root of their API. The repositories on the other hosts are rejected with ErrValidation
+	Hosts Hosts
+	// PerPage is the number of issues requested for each page
+	PerPage int
+	// MaxPages is the maximum number of pages followed by a request. A listing of the issues that exceeds it is
+	// resumed by the next one
+	MaxPages int
+	// RateLimitWait is the longest delay a request can wait for the rate limit reset before failing
+	// with RateLimitedError
+	RateLimitWait time.Duration
+	// CacheSize is the memory, in bytes, used to cache responses for conditional requests. A negative value
+	// disables the cache
+	CacheSize int64
+	// FullSyncInterval is how often all the issues of a repository are listed, instead of only the ones
+	// updated since the previous listing
+	FullSyncInterval time.Duration
+	// Credentials provides the tokens used to authenticate the requests. The token is read from the
+	// GITHUB_TOKEN environment variable if not set
+	Credentials TokenSource
+	// Timeout is the deadline of each request sent to Github
+	Timeout time.Duration
+	// Transport sends the requests to Github. http.DefaultTransport is used if not set
+	Transport http.RoundTripper
+	// Middlewares wrap Transport, the first one being the outermost. See DefaultMiddlewares for the built-in ones
+	Middlewares []Middleware
+
+	rateLimits rateLimitTracker
+	responses  responseCache
+	known      knownIssues
+	prs        knownPRs
 }
 
-func (g *GClient) GetTickets(repo string) ([]GithubTicket, error) {
+// GetTickets returns all the issues of the repository. After the first call, only the issues updated since
+// the newest known one are requested and merged with the known ones.
+func (g *GClient) GetTickets(ctx context.Context, repo string) ([]GithubTicket, error) {
 	requestUrl, err := g.getAPIBaseURL(repo)
 	if err != nil {
 		return []GithubTicket{}, err
 	}
-	requestUrl += "/issues?state=all"
-	res, err := sendRequest("GET", requestUrl, nil)
+	requestUrl += "/issues"
+	issuesUrl := requestUrl
+	// the issues are listed from the least recently updated, so that a listing stopped at the maximum number of
+	// pages is resumed from the newest issue it got
+	requestUrl += fmt.Sprintf("?state=all&sort=updated&direction=asc&per_page=%d", g.perPage())
+	since, full := g.known.since(issuesUrl, g.fullSyncInterval())
+	if !since.IsZero() {
+		requestUrl += "&since=" + url.QueryEscape(since.UTC().Format(time.RFC3339))
+	}
+	fetchedAt := time.Now()
+	updatedIssues, complete, err := g.getIssues(ctx, repo, requestUrl)
 	if err != nil {
 		return []GithubTicket{}, err
 	}
-	if res.StatusCode != http.StatusOK {
-		return []GithubTicket{}, fmt.Errorf("request %s returned with wrong code: %v", requestUrl, res.Status)
+	if !complete && !newestIssue(updatedIssues).After(since) {
+		// since is inclusive, the next listing would get the same issues
+		return []GithubTicket{}, fmt.Errorf("issues of %s updated at %s exceeded the maximum number of pages (%d)",
+			repo, since.UTC().Format(time.RFC3339), g.maxPages())
 	}
-	defer res.Body.Close()
-
-	var allIssues []githubIssue
-	err = json.NewDecoder(res.Body).Decode(&allIssues)
-	if err != nil {
-		return []GithubTicket{}, fmt.Errorf("can't decode body: %v", err)
+	allIssues, listed := g.known.merge(issuesUrl, updatedIssues, full, complete, fetchedAt)
+	if !listed {
+		return []GithubTicket{}, fmt.Errorf("listing the issues of %s exceeded the maximum number of pages (%d), "+
+			"it continues at the next request: %w", repo, g.maxPages(), ErrTransient)
 	}
 
 	ticketMap := make(map[int]GithubTicket, 1)
-	var ticketsWithPR []int
 	for _, i := range allIssues {
+		// the issues API lists the PRs too
 		if len(i.PullRequest) == 0 {
-			newTicket := GithubTicket{
-				Number:        i.Number,
-				Title:         i.Title,
-				Body:          i.Body,
-				RepositoryURL: i.RepositoryURL,
-				State:         i.State,
-				HasPr:         false,
-			}
+			newTicket := i.toTicket()
 			ticketMap[int(newTicket.Number)] = newTicket
-		} else {
-			numbers := ExtractReferencedIssue(i.Body)
-			for _, n := range numbers {
-				ticketsWithPR = append(ticketsWithPR, n)
-			}
 		}
 	}
-	for _, number := range ticketsWithPR {
-		cpy := ticketMap[number]
-		cpy.HasPr = true
-		ticketMap[number] = cpy
-	}
 
 	var tickets []GithubTicket
 	for _, ticket := range ticketMap {
@@ -96,98 +197,534 @@ func (g *GClient) GetTickets(repo string) ([]GithubTicket, error) {
 	return tickets, nil
 }
 
-func (g *GClient) CreateTicket(t GithubTicket) error {
-	requestBody, err := json.Marshal(map[string]string{
-		"title": t.Title,
-		"body":  t.Body,
-	})
+// GetTicket returns the issue with the given number, or nil if the repository has no such issue.
+func (g *GClient) GetTicket(ctx context.Context, repo string, number int64) (*GithubTicket, error) {
+	requestUrl, err := g.getAPIBaseURL(repo)
+	if err != nil {
+		return nil, err
+	}
+	requestUrl += fmt.Sprintf("/issues/%d", number)
+	res, err := g.sendRequest(ctx, repo, "GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	// a transferred or deleted issue is reported as not found
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, newResponseError(requestUrl, res)
+	}
+
+	var issue githubIssue
+	err = json.NewDecoder(res.Body).Decode(&issue)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode body: %v", err)
+	}
+	if len(issue.PullRequest) != 0 {
+		// the number belongs to a PR, not to an issue
+		return nil, nil
+	}
+
+	ticket := issue.toTicket()
+	return &ticket, nil
+}
+
+// CreateTicket creates a new issue and returns it as created by Github
+func (g *GClient) CreateTicket(ctx context.Context, t GithubTicket) (*GithubTicket, error) {
+	baseUrl, err := g.getAPIBaseURL(t.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+	request := map[string]interface{}{
+		"title":     t.Title,
+		"body":      t.Body,
+		"labels":    nonNil(t.Labels),
+		"assignees": nonNil(t.Assignees),
+	}
+	if t.Milestone != "" {
+		request["milestone"], err = g.getMilestoneNumber(ctx, t.RepositoryURL, baseUrl, t.Milestone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	requestUrl := baseUrl + "/issues"
+	res, err := g.sendRequest(ctx, t.RepositoryURL, "POST", requestUrl, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return nil, newResponseError(requestUrl, res)
+	}
+
+	var issue githubIssue
+	err = json.NewDecoder(res.Body).Decode(&issue)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode body: %v", err)
+	}
+	created := issue.toTicket()
+	return &created, nil
+}
+
+// UpdateTicket applies to the issue the changes from current to desired. Only the changed fields are sent, and
+// the labels and assignees are added and removed one by one, so that the ones set on Github meanwhile are kept.
+// The milestone is looked up only when it changes. An AssigneesDroppedError is returned, once the other changes
+// are applied, if Github did not assign some of the desired assignees.
+// desired.RepositoryURL is the API URL of the repository, as returned by Github.
+func (g *GClient) UpdateTicket(ctx context.Context, current, desired GithubTicket) error {
+	issueUrl := fmt.Sprintf("%s/issues/%d", desired.RepositoryURL, desired.Number)
+	repo := desired.RepositoryURL
+
+	request := map[string]interface{}{}
+	if desired.Title != current.Title {
+		request["title"] = desired.Title
+	}
+	if desired.Body != current.Body {
+		request["body"] = desired.Body
+	}
+	if desired.State != current.State || desired.StateReason != current.StateReason {
+		request["state"] = desired.State
+		if desired.StateReason != "" && desired.StateReason != "reopened" {
+			request["state_reason"] = desired.StateReason
+		}
+	}
+	if desired.Milestone != current.Milestone {
+		request["milestone"] = nil
+		if desired.Milestone != "" {
+			milestone, err := g.getMilestoneNumber(ctx, repo, repo, desired.Milestone)
+			if err != nil {
+				return err
+			}
+			request["milestone"] = milestone
+		}
+	}
+	if len(request) > 0 {
+		if err := g.sendJSON(ctx, repo, "POST", issueUrl, request, http.StatusOK); err != nil {
+			return err
+		}
+	}
+
+	added, removed := diffValues(current.Labels, desired.Labels)
+	if len(added) > 0 {
+		request := map[string][]string{"labels": added}
+		if err := g.sendJSON(ctx, repo, "POST", issueUrl+"/labels", request, http.StatusOK); err != nil {
+			return err
+		}
+	}
+	for _, label := range removed {
+		labelUrl := issueUrl + "/labels/" + url.PathEscape(label)
+		err := g.sendJSON(ctx, repo, "DELETE", labelUrl, nil, http.StatusOK)
+		// the label was removed on Github meanwhile
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	added, removed = diffValues(current.Assignees, desired.Assignees)
+	if len(removed) > 0 {
+		request := map[string][]string{"assignees": removed}
+		if err := g.sendJSON(ctx, repo, "DELETE", issueUrl+"/assignees", request, http.StatusOK); err != nil {
+			return err
+		}
+	}
+	if len(added) > 0 {
+		return g.addAssignees(ctx, repo, issueUrl, added)
+	}
+	return nil
+}
+
+// addAssignees adds the assignees to the issue, and returns an AssigneesDroppedError if Github did not assign
+// some of them
+func (g *GClient) addAssignees(ctx context.Context, repo, issueUrl string, assignees []string) error {
+	requestUrl := issueUrl + "/assignees"
+	requestBody, err := json.Marshal(map[string][]string{"assignees": assignees})
+	if err != nil {
+		return err
+	}
+	res, err := g.sendRequest(ctx, repo, "POST", requestUrl, requestBody)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return newResponseError(requestUrl, res)
+	}
+
+	var issue githubIssue
+	if err := json.NewDecoder(res.Body).Decode(&issue); err != nil {
+		return fmt.Errorf("can't decode body: %v", err)
+	}
+	assigned := issue.toTicket().Assignees
+	var dropped []string
+	for _, a := range assignees {
+		if !containsFold(assigned, a) {
+			dropped = append(dropped, a)
+		}
+	}
+	if len(dropped) > 0 {
+		return &AssigneesDroppedError{URL: requestUrl, Assignees: dropped}
+	}
+	return nil
+}
 
-	requestUrl, err := g.getAPIBaseURL(t.RepositoryURL)
+// CommentTicket adds a comment with the given body to the issue.
+// t.RepositoryURL is the API URL of the repository, as returned by Github.
+func (g *GClient) CommentTicket(ctx context.Context, t GithubTicket, body string) error {
+	requestBody, err := json.Marshal(map[string]string{"body": body})
 	if err != nil {
 		return err
 	}
-	requestUrl += "/issues"
-	res, err := sendRequest("POST", requestUrl, requestBody)
+
+	request_url := fmt.Sprintf("%s/issues/%d/comments", t.RepositoryURL, t.Number)
+	res, err := g.sendRequest(ctx, t.RepositoryURL, "POST", request_url, requestBody)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusCreated {
-		return fmt.Errorf("request %s returned with wrong code: %v", requestUrl, res.Status)
+		return newResponseError(request_url, res)
+	}
+	return nil
+}
+
+// LockTicket locks the conversation of the issue, so that only the collaborators can comment it.
+// t.RepositoryURL is the API URL of the repository, as returned by Github.
+func (g *GClient) LockTicket(ctx context.Context, t GithubTicket) error {
+	request_url := fmt.Sprintf("%s/issues/%d/lock", t.RepositoryURL, t.Number)
+	res, err := g.sendRequest(ctx, t.RepositoryURL, "PUT", request_url, nil)
+	if err != nil {
+		return err
 	}
-	return err
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return newResponseError(request_url, res)
+	}
+	return nil
 }
 
-func (g *GClient) UpdateTicket(t GithubTicket) error {
-	requestBody, err := json.Marshal(map[string]string{
-		"title": t.Title,
-		"body":  t.Body,
-		"state": t.State,
+// getMilestoneNumber returns the number of the milestone with the given title, which Github requires to set
+// the milestone of an issue. repoUrl is the API URL of the repository.
+func (g *GClient) getMilestoneNumber(ctx context.Context, repo, repoUrl, title string) (int64, error) {
+	var number int64
+	requestUrl := fmt.Sprintf("%s/milestones?state=all&per_page=%d", repoUrl, g.perPage())
+	err := g.getAllPages(ctx, repo, requestUrl, func(body io.Reader) error {
+		var milestones []githubMilestone
+		if err := json.NewDecoder(body).Decode(&milestones); err != nil {
+			return err
+		}
+		for _, m := range milestones {
+			if m.Title == title {
+				number = m.Number
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	if number == 0 {
+		return 0, fmt.Errorf("milestone '%s' not found in %s: %w", title, repo, ErrValidation)
+	}
+	return number, nil
+}
+
+// sendJSON sends the request, with the JSON encoding of request as body unless nil, and returns an error if the
+// response status is not the expected one
+func (g *GClient) sendJSON(ctx context.Context, repo, method, requestUrl string, request interface{}, expected int) error {
+	var requestBody []byte
+	if request != nil {
+		var err error
+		if requestBody, err = json.Marshal(request); err != nil {
+			return err
+		}
+	}
+	res, err := g.sendRequest(ctx, repo, method, requestUrl, requestBody)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
+	if res.StatusCode != expected {
+		return newResponseError(requestUrl, res)
+	}
+	return nil
+}
+
+// diffValues returns the values of desired missing from current, and the values of current missing from
+// desired. Values are compared ignoring case, like Github does for labels and logins.
+func diffValues(current, desired []string) (added, removed []string) {
+	for _, v := range desired {
+		if !containsFold(current, v) {
+			added = append(added, v)
+		}
+	}
+	for _, v := range current {
+		if !containsFold(desired, v) {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// nonNil returns an empty slice instead of nil, which Github does not accept as a list
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
 
-	request_url := fmt.Sprintf("%s/issues/%d", t.RepositoryURL, t.Number)
-	res, err := sendRequest("POST", request_url, requestBody)
+// getIssues follows the "next" links of the paginated issues API and returns the issues of all pages, up to the
+// maximum number of pages. complete is false if there are more pages.
+func (g *GClient) getIssues(ctx context.Context, repo, requestUrl string) ([]githubIssue, bool, error) {
+	var allIssues []githubIssue
+	next, err := g.getPages(ctx, repo, requestUrl, func(body io.Reader) error {
+		var issues []githubIssue
+		if err := json.NewDecoder(body).Decode(&issues); err != nil {
+			return err
+		}
+		allIssues = append(allIssues, issues...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return allIssues, next == "", nil
+}
+
+// newestIssue returns the newest update time of the issues
+func newestIssue(issues []githubIssue) time.Time {
+	return addIssues(make(map[int64]githubIssue, len(issues)), issues, time.Time{})
+}
+
+// getAllPages follows the "next" links of a paginated API and calls decodePage with the body of every page
+func (g *GClient) getAllPages(ctx context.Context, repo, requestUrl string, decodePage func(io.Reader) error) error {
+	next, err := g.getPages(ctx, repo, requestUrl, decodePage)
 	if err != nil {
 		return err
 	}
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("request to %s returned with wrong code: %v", request_url, res.Status)
+	if next != "" {
+		return fmt.Errorf("request %s exceeded the maximum number of pages (%d)", next, g.maxPages())
+	}
+	return nil
+}
+
+// getPages follows the "next" links of a paginated API, up to the maximum number of pages, and calls decodePage
+// with the body of every page. It returns the URL of the next page, or an empty string if there are no more.
+func (g *GClient) getPages(ctx context.Context, repo, requestUrl string, decodePage func(io.Reader) error) (string, error) {
+	for page := 0; requestUrl != "" && page < g.maxPages(); page++ {
+		res, err := g.sendRequest(ctx, repo, "GET", requestUrl, nil)
+		if err != nil {
+			return "", err
+		}
+		if res.StatusCode != http.StatusOK {
+			err = newResponseError(requestUrl, res)
+			res.Body.Close()
+			return "", err
+		}
+
+		err = decodePage(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("can't decode body: %v", err)
+		}
+		requestUrl = nextPageURL(res.Header.Get("Link"))
+	}
+	return requestUrl, nil
+}
+
+func (g *GClient) perPage() int {
+	if g.PerPage <= 0 {
+		return DEFAULT_PER_PAGE
 	}
-	return err
+	return g.PerPage
 }
 
-func (g *GClient) IssueHasPR(t GithubTicket) bool {
-	return t.HasPr
+func (g *GClient) maxPages() int {
+	if g.MaxPages <= 0 {
+		return DEFAULT_MAX_PAGES
+	}
+	return g.MaxPages
 }
 
+// getAPIBaseURL returns the API URL of the repository, using BaseURL for github.com and Hosts for the other
+// hosts. The repositories on any other host are rejected, rather than looked up on github.com.
 func (g *GClient) getAPIBaseURL(repo string) (string, error) {
 	output, err := url.Parse(repo)
 	if err != nil {
 		return "", fmt.Errorf("could not recognize repository URL: %v\n", err)
 	}
-	// output.Path has format "/OWNER/REPOSITORY", do not add another "/" between BaseURL and output.Path
-	return fmt.Sprintf("%s%s", g.BaseURL, output.Path), nil
+	if strings.EqualFold(output.Host, GITHUB_HOST) {
+		// output.Path has format "/OWNER/REPOSITORY", do not add another "/" between BaseURL and output.Path
+		return fmt.Sprintf("%s%s", g.BaseURL, output.Path), nil
+	}
+	if apiURL, ok := g.Hosts.apiURL(output.Host); ok {
+		return fmt.Sprintf("%s/repos%s", apiURL, output.Path), nil
+	}
+	return "", fmt.Errorf("repository host '%s' is not configured in the operator: %w", output.Host, ErrValidation)
 }
 
-func sendRequest(method, url string, data []byte) (*http.Response, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(data))
+func (g *GClient) rateLimitWait() time.Duration {
+	if g.RateLimitWait <= 0 {
+		return DEFAULT_RATE_LIMIT_WAIT
+	}
+	return g.RateLimitWait
+}
+
+func (g *GClient) fullSyncInterval() time.Duration {
+	if g.FullSyncInterval <= 0 {
+		return DEFAULT_FULL_SYNC_INTERVAL
+	}
+	return g.FullSyncInterval
+}
+
+func (g *GClient) cacheSize() int64 {
+	if g.CacheSize == 0 {
+		return DEFAULT_CACHE_SIZE
+	}
+	return g.CacheSize
+}
+
+func (g *GClient) credentials() TokenSource {
+	if g.Credentials == nil {
+		return EnvToken{}
+	}
+	return g.Credentials
+}
+
+func (g *GClient) timeout() time.Duration {
+	if g.Timeout <= 0 {
+		return DEFAULT_REQUEST_TIMEOUT
+	}
+	return g.Timeout
+}
+
+// sendRequest sends the request once the token has budget left. If Github refuses the request because of
+// a rate limit, the request is retried once, unless the reset is farther than RateLimitWait.
+// Each attempt has its own deadline, which lasts until the response body is closed.
+// repo is the URL of the repository the request is about, and selects the credentials.
+func (g *GClient) sendRequest(ctx context.Context, repo, method, url string, data []byte) (*http.Response, error) {
+	client := &http.Client{Transport: Chain(g.Transport, g.Middlewares...)}
+
+	token, err := g.credentials().Token(ctx, repo)
 	if err != nil {
 		return nil, err
 	}
+	resource := rateLimitResource(url)
+
+	for attempt := 0; ; attempt++ {
+		if reset := g.rateLimits.exhaustedUntil(token, resource); !reset.IsZero() {
+			wait := time.Until(reset)
+			if wait > g.rateLimitWait() {
+				return nil, &RateLimitedError{URL: url, Reset: reset}
+			}
+			select {
+			case <-ctx.Done():
+				return nil, newTransportError(url, ctx.Err())
+			case <-time.After(wait):
+			}
+		}
+
+		res, err := g.sendRequestAttempt(ctx, client, repo, token, resource, method, url, data)
+		if err != nil || res != nil {
+			return res, err
+		}
+		if attempt > 0 {
+			return nil, &RateLimitedError{URL: url, Reset: g.rateLimits.exhaustedUntil(token, resource)}
+		}
+	}
+}
 
-	token := os.Getenv("GITHUB_TOKEN")
-	if token == "" {
-		return nil, fmt.Errorf("could not get github token for '%s'", url)
+// sendRequestAttempt sends the request and returns a nil response if it was refused because of a rate limit
+func (g *GClient) sendRequestAttempt(ctx context.Context, client *http.Client, repo, token, resource, method, url string, data []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout())
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(data))
+	if err != nil {
+		cancel()
+		return nil, err
 	}
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("Authorization", "Bearer "+token)
+	useCache := method == http.MethodGet && g.CacheSize >= 0
+	if useCache {
+		g.responses.addConditionalHeaders(req)
+	}
 	res, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("issues request to %s failed: %s", url, err)
+		cancel()
+		return nil, newTransportError(url, err)
+	}
+
+	if observer, ok := g.credentials().(tokenExpirationObserver); ok {
+		if expiration, err := parseTokenExpiration(res.Header.Get(tokenExpirationHeader)); err == nil {
+			observer.observeExpiration(repo, token, expiration)
+		}
+	}
+	if reset := g.rateLimits.update(token, resource, res); !reset.IsZero() {
+		res.Body.Close()
+		cancel()
+		return nil, nil
 	}
+	if useCache {
+		res, err = g.responses.resolve(req, res, g.cacheSize())
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
 	return res, nil
 }
 
+// cancelOnClose releases the request context once the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// nextPageURL returns the URL with rel="next" from a Link header, or an empty string if there is none.
+// The header has format `<https://api.github.com/...?page=2>; rel="next", <https://api.github.com/...?page=5>; rel="last"`
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		sections := strings.Split(part, ";")
+		if len(sections) < 2 {
+			continue
+		}
+		for _, param := range sections[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(sections[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// ExtractReferencedIssue returns the numbers of the issues of the same repository that a PR body declares to close
 func ExtractReferencedIssue(body string) []int {
 	var numbers []int
-	re := regexp.MustCompile("[close|closes|closed|fix|fixes|fixed|resolve|resolves|resolved]:? #([0-9]+)")
-
-	matches := re.FindAllStringSubmatch(body, -1)
-	for _, parts := range matches {
-		if len(parts) >= 2 {
-			for _, p := range parts[1:] {
-				number, err := strconv.Atoi(p)
-				if err != nil {
-					continue
-				}
-				numbers = append(numbers, number)
-			}
+	for _, ref := range ExtractClosingReferences(body) {
+		if ref.Owner == "" {
+			numbers = append(numbers, int(ref.Number))
 		}
 	}
 	return numbers
@@ -1,12 +1,16 @@
 package gclient_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/clobrano/githubissues-operator/controllers/gclient"
 	. "github.com/onsi/ginkgo/v2"
@@ -63,19 +67,130 @@ var _ = Describe("Github client", func() {
 		defer ts.Close()
 
 		wanted := []gclient.GithubTicket{
-			{1, "issue 1 title", "issue 1 description", "open", "", false},
-			{2, "issue 2 title", "issue 2 description", "closed", "", false},
-			{3, "issue 3 title", "issue 3 description", "open", "", true},
+			{Number: 1, Title: "issue 1 title", Body: "issue 1 description", State: "open"},
+			{Number: 2, Title: "issue 2 title", Body: "issue 2 description", State: "closed"},
+			{Number: 3, Title: "issue 3 title", Body: "issue 3 description", State: "open"},
 		}
 		// Use NewServer URL as BaseURL to prevent sending request to the real Github servers
 		underTest := gclient.GClient{BaseURL: ts.URL}
-		tickets, err := underTest.GetTickets(ts.URL)
+		tickets, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
 		Expect(err).To(BeNil())
 		Expect(tickets).To(ContainElements(wanted))
 
 		os.Unsetenv("GITHUB_TOKEN")
 	})
 
+	It("can get the issues from all the pages of a repository", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+
+		pages := map[string]string{
+			"1": `[
+				{"number": 1, "title": "issue 1 title", "body": "issue 1 description", "state": "open"},
+				{"number": 2, "title": "issue 2 title", "body": "issue 2 description", "state": "closed"}
+			]`,
+			"2": `[
+				{"number": 3, "title": "issue 3 title", "body": "issue 3 description", "state": "open"},
+				{"number": 4, "title": "issue 4 title", "body": "issue 4 description", "state": "open"}
+			]`,
+			"3": `[
+				{"number": 11, "title": "PR to fix issue 1", "body": "Fixes #1", "state": "open",
+				 "pull_request": {"diff_url": "just a field to have non-empty pull_request field"}}
+			]`,
+		}
+		var ts *httptest.Server
+		var perPage string
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			perPage = r.URL.Query().Get("per_page")
+			page := r.URL.Query().Get("page")
+			if page == "" {
+				page = "1"
+			}
+			body, ok := pages[page]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			next, _ := strconv.Atoi(page)
+			if _, ok := pages[strconv.Itoa(next+1)]; ok {
+				w.Header().Set("Link", fmt.Sprintf(
+					`<%s%s?state=all&per_page=2&page=%d>; rel="next", <%s%s?state=all&per_page=2&page=3>; rel="last"`,
+					ts.URL, r.URL.Path, next+1, ts.URL, r.URL.Path))
+			}
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, body)
+		}))
+		defer ts.Close()
+
+		wanted := []gclient.GithubTicket{
+			{Number: 1, Title: "issue 1 title", Body: "issue 1 description", State: "open"},
+			{Number: 2, Title: "issue 2 title", Body: "issue 2 description", State: "closed"},
+			{Number: 3, Title: "issue 3 title", Body: "issue 3 description", State: "open"},
+			{Number: 4, Title: "issue 4 title", Body: "issue 4 description", State: "open"},
+		}
+		underTest := gclient.GClient{BaseURL: ts.URL, PerPage: 2}
+		tickets, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(tickets).To(ConsistOf(wanted))
+		Expect(perPage).To(Equal("2"))
+
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("returns an error if the issues span more pages than allowed", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+
+		var ts *httptest.Server
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// every page links to another one
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s?state=all&page=next>; rel="next"`, ts.URL, r.URL.Path))
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `[{"number": 1, "title": "issue 1 title", "body": "issue 1 description", "state": "open"}]`)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL, MaxPages: 3}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("maximum number of pages (3)"))
+
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("can get a single issue", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+
+		var ts *httptest.Server
+		var requestedPaths []string
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestedPaths = append(requestedPaths, r.URL.Path)
+			repoURL := ts.URL + "/owner/repo"
+			switch r.URL.Path {
+			case "/owner/repo/issues/3":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprintf(w, `{"number": 3, "title": "issue 3 title", "body": "issue 3 description", "state": "open", "repository_url": "%s"}`, repoURL)
+			case "/owner/repo/issues/4":
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		ticket, err := underTest.GetTicket(context.TODO(), "https://github.com/owner/repo", 3)
+		Expect(err).To(BeNil())
+		Expect(ticket).To(Equal(&gclient.GithubTicket{
+			Number: 3, Title: "issue 3 title", Body: "issue 3 description", State: "open",
+			RepositoryURL: ts.URL + "/owner/repo"}))
+		Expect(requestedPaths).To(Equal([]string{"/owner/repo/issues/3"}))
+
+		ticket, err = underTest.GetTicket(context.TODO(), "https://github.com/owner/repo", 4)
+		Expect(err).To(BeNil())
+		Expect(ticket).To(BeNil())
+
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
 	It("can create a new ticket", func() {
 		os.Setenv("GITHUB_TOKEN", "fake github token")
 		var newTicketReq gclient.GithubTicket
@@ -91,19 +206,266 @@ var _ = Describe("Github client", func() {
 				w.WriteHeader(http.StatusBadRequest)
 			}
 			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"number": 42, "title": "%s", "body": "%s", "state": "open", "html_url": "https://github.com/owner/repo/issues/42"}`,
+				newTicketReq.Title, newTicketReq.Body)
 		}))
 		defer ts.Close()
 
 		// Use NewServer URL as BaseURL to prevent sending request to the real Github servers
 		underTest := gclient.GClient{BaseURL: ts.URL}
 
-		err := underTest.CreateTicket(
-			gclient.GithubTicket{0, "new issue title", "new issue description", "open", ts.URL, false})
+		created, err := underTest.CreateTicket(context.TODO(), gclient.GithubTicket{
+			Title: "new issue title", Body: "new issue description", State: "open", RepositoryURL: "https://github.com/owner/repo"})
 		Expect(err).To(BeNil())
 		Expect(newTicketReq).To(And(
 			HaveField("Title", "new issue title"),
 			HaveField("Body", "new issue description"),
 		))
+		Expect(created).To(Equal(&gclient.GithubTicket{
+			Number: 42, Title: "new issue title", Body: "new issue description", State: "open",
+			HTMLURL: "https://github.com/owner/repo/issues/42"}))
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("sends labels, assignees and milestone", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		var requests []map[string]interface{}
+
+		var ts *httptest.Server
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/owner/repo/milestones":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `[{"number": 1, "title": "v1.0"}, {"number": 2, "title": "v2.0"}]`)
+				return
+			}
+			var request map[string]interface{}
+			Expect(json.NewDecoder(r.Body).Decode(&request)).To(Succeed())
+			requests = append(requests, request)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"number": 42, "repository_url": "%s/owner/repo", "labels": [{"name": "bug"}],
+				"assignees": [{"login": "alice"}], "milestone": {"number": 2, "title": "v2.0"}}`, ts.URL)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		created, err := underTest.CreateTicket(context.TODO(), gclient.GithubTicket{
+			Title: "title", RepositoryURL: "https://github.com/owner/repo",
+			Labels: []string{"bug"}, Assignees: []string{"alice"}, Milestone: "v2.0"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created.Labels).To(Equal([]string{"bug"}))
+		Expect(created.Assignees).To(Equal([]string{"alice"}))
+		Expect(created.Milestone).To(Equal("v2.0"))
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0]).To(And(
+			HaveKeyWithValue("labels", []interface{}{"bug"}),
+			HaveKeyWithValue("assignees", []interface{}{"alice"}),
+			HaveKeyWithValue("milestone", BeEquivalentTo(2)),
+		))
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("sends only the changes of the ticket", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		var requests []string
+		var bodies []map[string]interface{}
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/owner/repo/milestones" {
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `[{"number": 1, "title": "v1.0"}, {"number": 2, "title": "v2.0"}]`)
+				return
+			}
+			requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+				bodies = append(bodies, body)
+			}
+			switch {
+			case r.Method == "POST" && r.URL.Path == "/owner/repo/issues/42/assignees":
+				// Github silently ignores the users that cannot be assigned
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"number": 42, "assignees": [{"login": "carol"}, {"login": "bob"}]}`)
+				return
+			case r.URL.Path == "/owner/repo/issues/42/labels/help%20wanted":
+				// the label was removed on Github meanwhile
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				w.WriteHeader(http.StatusOK)
+			}
+			fmt.Fprint(w, `{}`)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		current := gclient.GithubTicket{Number: 42, Title: "title", Body: "body", State: "open",
+			RepositoryURL: ts.URL + "/owner/repo", Labels: []string{"bug", "help wanted", "external"},
+			Assignees: []string{"alice", "carol"}, Milestone: "v1.0"}
+		Expect(underTest.UpdateTicket(context.TODO(), current, current)).To(Succeed())
+		Expect(requests).To(BeEmpty())
+
+		desired := current
+		desired.Title = "new title"
+		desired.Labels = []string{"BUG", "external", "feature"}
+		desired.Assignees = []string{"carol", "bob"}
+		Expect(underTest.UpdateTicket(context.TODO(), current, desired)).To(Succeed())
+		Expect(requests).To(Equal([]string{
+			"POST /owner/repo/issues/42",
+			"POST /owner/repo/issues/42/labels",
+			"DELETE /owner/repo/issues/42/labels/help%20wanted",
+			"DELETE /owner/repo/issues/42/assignees",
+			"POST /owner/repo/issues/42/assignees",
+		}))
+		Expect(bodies).To(Equal([]map[string]interface{}{
+			{"title": "new title"},
+			{"labels": []interface{}{"feature"}},
+			{"assignees": []interface{}{"alice"}},
+			{"assignees": []interface{}{"bob"}},
+		}))
+
+		desired.Assignees = []string{"carol", "bob", "mallory"}
+		err := underTest.UpdateTicket(context.TODO(), current, desired)
+		var dropped *gclient.AssigneesDroppedError
+		Expect(errors.As(err, &dropped)).To(BeTrue())
+		Expect(dropped.Assignees).To(Equal([]string{"mallory"}))
+
+		requests, bodies = nil, nil
+		desired = current
+		desired.Milestone = "v2.0"
+		Expect(underTest.UpdateTicket(context.TODO(), current, desired)).To(Succeed())
+		desired.Milestone = ""
+		Expect(underTest.UpdateTicket(context.TODO(), current, desired)).To(Succeed())
+		Expect(bodies).To(Equal([]map[string]interface{}{
+			{"milestone": float64(2)},
+			{"milestone": nil},
+		}))
+
+		desired.Milestone = "v3.0"
+		Expect(errors.Is(underTest.UpdateTicket(context.TODO(), current, desired), gclient.ErrValidation)).To(BeTrue())
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("sends the reason of the closed state", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		var requests []map[string]interface{}
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var request map[string]interface{}
+			Expect(json.NewDecoder(r.Body).Decode(&request)).To(Succeed())
+			requests = append(requests, request)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		open := gclient.GithubTicket{Number: 42, Title: "title", RepositoryURL: ts.URL + "/owner/repo", State: "open"}
+		closed := open
+		closed.State = "closed"
+		closed.StateReason = "not_planned"
+		Expect(underTest.UpdateTicket(context.TODO(), open, closed)).To(Succeed())
+
+		reopened := open
+		reopened.StateReason = "reopened"
+		Expect(underTest.UpdateTicket(context.TODO(), closed, reopened)).To(Succeed())
+
+		Expect(requests).To(Equal([]map[string]interface{}{
+			{"state": "closed", "state_reason": "not_planned"},
+			{"state": "open"},
+		}))
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("comments and locks the issue", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		var requests []string
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			switch r.URL.Path {
+			case "/owner/repo/issues/42/comments":
+				var request map[string]string
+				Expect(json.NewDecoder(r.Body).Decode(&request)).To(Succeed())
+				Expect(request).To(HaveKeyWithValue("body", "Not tracked anymore"))
+				w.WriteHeader(http.StatusCreated)
+			case "/owner/repo/issues/42/lock":
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		ticket := gclient.GithubTicket{Number: 42, RepositoryURL: ts.URL + "/owner/repo"}
+		Expect(underTest.CommentTicket(context.TODO(), ticket, "Not tracked anymore")).To(Succeed())
+		Expect(underTest.LockTicket(context.TODO(), ticket)).To(Succeed())
+
+		ticket.Number = 43
+		Expect(errors.Is(underTest.LockTicket(context.TODO(), ticket), gclient.ErrNotFound)).To(BeTrue())
+
+		Expect(requests).To(Equal([]string{
+			"POST /owner/repo/issues/42/comments",
+			"PUT /owner/repo/issues/42/lock",
+			"PUT /owner/repo/issues/43/lock",
+		}))
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("gives up on requests that exceed the timeout", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+
+		release := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer ts.Close()
+		defer close(release)
+
+		underTest := gclient.GClient{BaseURL: ts.URL, Timeout: 50 * time.Millisecond}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(errors.Is(err, gclient.ErrTransient)).To(BeTrue())
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		underTest = gclient.GClient{BaseURL: ts.URL}
+		_, err = underTest.GetTicket(ctx, "https://github.com/owner/repo", 1)
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("returns typed errors for the failed requests", func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+
+		var status int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"message": "error message from Github"}`)
+		}))
+		defer ts.Close()
+
+		tt := []struct {
+			status int
+			want   error
+		}{
+			{http.StatusUnauthorized, gclient.ErrUnauthorized},
+			{http.StatusForbidden, gclient.ErrForbidden},
+			{http.StatusNotFound, gclient.ErrNotFound},
+			{http.StatusUnprocessableEntity, gclient.ErrValidation},
+			{http.StatusInternalServerError, gclient.ErrTransient},
+			{http.StatusBadGateway, gclient.ErrTransient},
+		}
+		for _, tc := range tt {
+			status = tc.status
+			underTest := gclient.GClient{BaseURL: ts.URL}
+			_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+			Expect(errors.Is(err, tc.want)).To(BeTrue(), "status %d", tc.status)
+			Expect(err.Error()).To(ContainSubstring("error message from Github"))
+		}
+
 		os.Unsetenv("GITHUB_TOKEN")
 	})
 
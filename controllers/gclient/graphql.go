@@ -0,0 +1,87 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GITHUB_GRAPHQL_PATH is the path of the GraphQL API, relative to the root of the REST API on github.com and to
+// the /api path on a Github Enterprise Server host
+const GITHUB_GRAPHQL_PATH string = "/graphql"
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// sendGraphQL sends the query about the repository to the GraphQL API of its host, and decodes the data of the
+// response in out. The errors reported by Github in the response are returned as errors.
+func (g *GClient) sendGraphQL(ctx context.Context, repo, query string, variables map[string]interface{}, out interface{}) error {
+	requestUrl, err := g.getGraphQLURL(repo)
+	if err != nil {
+		return err
+	}
+	requestBody, err := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return err
+	}
+
+	res, err := g.sendRequest(ctx, repo, "POST", requestUrl, requestBody)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return newResponseError(requestUrl, res)
+	}
+
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphQLError  `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return fmt.Errorf("can't decode body: %v", err)
+	}
+	if len(response.Errors) > 0 {
+		e := response.Errors[0]
+		switch e.Type {
+		case "NOT_FOUND":
+			return fmt.Errorf("graphql request to %s failed: %s: %w", requestUrl, e.Message, ErrNotFound)
+		case "FORBIDDEN":
+			return fmt.Errorf("graphql request to %s failed: %s: %w", requestUrl, e.Message, ErrForbidden)
+		case "RATE_LIMITED":
+			// Github reports the exhausted GraphQL budget with a successful response
+			return &RateLimitedError{URL: requestUrl, Reset: rateLimitReset(res)}
+		}
+		return fmt.Errorf("graphql request to %s failed: %s", requestUrl, e.Message)
+	}
+	if err := json.Unmarshal(response.Data, out); err != nil {
+		return fmt.Errorf("can't decode body: %v", err)
+	}
+	return nil
+}
+
+// getGraphQLURL returns the URL of the GraphQL API of the host of the repository: next to the REST API on
+// github.com, at /api/graphql on a Github Enterprise Server host
+func (g *GClient) getGraphQLURL(repo string) (string, error) {
+	output, err := url.Parse(repo)
+	if err != nil {
+		return "", fmt.Errorf("could not recognize repository URL: %v\n", err)
+	}
+	if strings.EqualFold(output.Host, GITHUB_HOST) {
+		return strings.TrimSuffix(g.BaseURL, "/repos") + GITHUB_GRAPHQL_PATH, nil
+	}
+	if apiURL, ok := g.Hosts.apiURL(output.Host); ok {
+		return strings.TrimSuffix(apiURL, "/v3") + GITHUB_GRAPHQL_PATH, nil
+	}
+	return "", fmt.Errorf("repository host '%s' is not configured in the operator: %w", output.Host, ErrValidation)
+}
@@ -0,0 +1,100 @@
+package gclient
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// GHES_API_PATH is the path of the API on a Github Enterprise Server host
+const GHES_API_PATH string = "/api/v3"
+
+// GITHUB_HOST is the web host of the repositories on github.com, whose API is at BaseURL
+const GITHUB_HOST string = "github.com"
+
+// Hosts maps the web hosts of the repositories (e.g. "github.example.com") to the root of their Github API
+// (e.g. "https://github.example.com/api/v3")
+type Hosts map[string]string
+
+// ParseHosts parses a comma separated list of "HOST" or "HOST=API_URL" entries. When API_URL is not set,
+// HOST is a Github Enterprise Server with the API at https://HOST/api/v3.
+func ParseHosts(value string) (Hosts, error) {
+	hosts := Hosts{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, apiURL, found := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			return nil, fmt.Errorf("invalid Github host '%s'", entry)
+		}
+		if !found {
+			apiURL = "https://" + host + GHES_API_PATH
+		}
+		u, err := url.Parse(strings.TrimSpace(apiURL))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid API URL for Github host '%s': '%s'", host, apiURL)
+		}
+		hosts[host] = strings.TrimSuffix(u.String(), "/")
+	}
+	return hosts, nil
+}
+
+// ParseHostTokenFiles parses a comma separated list of "HOST=PATH" entries, the files with the token of each host
+func ParseHostTokenFiles(value string) (map[string]string, error) {
+	files := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, path, found := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		path = strings.TrimSpace(path)
+		if !found || host == "" || strings.ContainsAny(host, "/ ") || path == "" {
+			return nil, fmt.Errorf("invalid Github host token file '%s'", entry)
+		}
+		files[host] = path
+	}
+	return files, nil
+}
+
+// apiURL returns the root of the API of the host, and if the host is known
+func (h Hosts) apiURL(host string) (string, bool) {
+	apiURL, ok := h[strings.ToLower(host)]
+	return apiURL, ok
+}
+
+// Names returns the web hosts
+func (h Hosts) Names() []string {
+	var names []string
+	for host := range h {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// webHost returns the web host of a repository from its web or API URL, and if it is github.com or one of the hosts
+func (h Hosts) webHost(repo string) (string, bool) {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", false
+	}
+	host := strings.ToLower(u.Host)
+	if host == GITHUB_HOST || host == "api."+GITHUB_HOST {
+		return GITHUB_HOST, true
+	}
+	if _, ok := h[host]; ok {
+		return host, true
+	}
+	for webHost, apiURL := range h {
+		if a, err := url.Parse(apiURL); err == nil && strings.ToLower(a.Host) == host {
+			return webHost, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,99 @@
+package gclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github hosts", func() {
+	var (
+		githubServer *httptest.Server
+		ghesServer   *httptest.Server
+		requested    []string
+	)
+
+	BeforeEach(func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		requested = nil
+		handler := func(name string) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				requested = append(requested, name+" "+r.URL.Path)
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`[]`))
+			}
+		}
+		githubServer = httptest.NewServer(handler("github"))
+		ghesServer = httptest.NewServer(handler("ghes"))
+	})
+
+	AfterEach(func() {
+		githubServer.Close()
+		ghesServer.Close()
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("parses the hosts configuration", func() {
+		hosts, err := gclient.ParseHosts("github.example.com, GHE.Example.org=https://api.ghe.example.org/ ,")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hosts).To(Equal(gclient.Hosts{
+			"github.example.com": "https://github.example.com/api/v3",
+			"ghe.example.org":    "https://api.ghe.example.org",
+		}))
+		Expect(hosts.Names()).To(Equal([]string{"ghe.example.org", "github.example.com"}))
+
+		_, err = gclient.ParseHosts("github.example.com=not-an-url")
+		Expect(err).To(HaveOccurred())
+		_, err = gclient.ParseHosts("https://github.example.com")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("parses the host token files configuration", func() {
+		files, err := gclient.ParseHostTokenFiles("GitHub.Example.com=/tokens/ghes, ghe.example.org = /tokens/ghe")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(Equal(map[string]string{
+			"github.example.com": "/tokens/ghes",
+			"ghe.example.org":    "/tokens/ghe",
+		}))
+
+		_, err = gclient.ParseHostTokenFiles("github.example.com")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("sends the requests to the API of the repository host", func() {
+		underTest := gclient.GClient{
+			BaseURL:   githubServer.URL + "/repos",
+			Hosts:     gclient.Hosts{"github.example.com": ghesServer.URL + "/api/v3"},
+			CacheSize: -1,
+		}
+
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = underTest.GetTickets(context.TODO(), "https://GitHub.Example.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requested).To(Equal([]string{
+			"github /repos/owner/repo/issues",
+			"ghes /api/v3/repos/owner/repo/issues",
+		}))
+	})
+
+	It("rejects the repositories on hosts not configured", func() {
+		underTest := gclient.GClient{
+			BaseURL:   githubServer.URL + "/repos",
+			Hosts:     gclient.Hosts{"github.example.com": ghesServer.URL + "/api/v3"},
+			CacheSize: -1,
+		}
+
+		_, err := underTest.GetTickets(context.TODO(), "https://gitlab.com/owner/repo")
+		Expect(errors.Is(err, gclient.ErrValidation)).To(BeTrue())
+		_, err = underTest.CreateTicket(context.TODO(), gclient.GithubTicket{RepositoryURL: "https://github.exampel.com/owner/repo"})
+		Expect(errors.Is(err, gclient.ErrValidation)).To(BeTrue())
+		Expect(requested).To(BeEmpty())
+	})
+})
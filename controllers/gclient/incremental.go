@@ -0,0 +1,103 @@
+package gclient
+
+import (
+	"sync"
+	"time"
+)
+
+// DEFAULT_FULL_SYNC_INTERVAL is how often all the issues of a repository are listed again when
+// GClient.FullSyncInterval is not set. Incremental updates can't detect deleted or transferred issues.
+const DEFAULT_FULL_SYNC_INTERVAL time.Duration = time.Hour
+
+// knownIssues keeps the issues (and PRs) already listed for each repository, so that only the issues
+// updated since the newest known one need to be requested. The issues are listed by update time, so a listing
+// longer than the maximum number of pages is resumed by the next one from the newest issue it got.
+type knownIssues struct {
+	mu           sync.Mutex
+	repositories map[string]*repositoryIssues
+}
+
+type repositoryIssues struct {
+	issues       map[int64]githubIssue
+	newest       time.Time
+	lastFullSync time.Time
+	// listed is true once a full listing of the repository completed
+	listed bool
+	// baseline collects the issues of the full listing in progress, which can span several listings
+	baseline *repositoryBaseline
+}
+
+type repositoryBaseline struct {
+	issues    map[int64]githubIssue
+	newest    time.Time
+	startedAt time.Time
+}
+
+// since returns the update time from which the issues of the repository must be listed, the zero time to list
+// all of them, and whether the listing is (a part of) a full one.
+func (k *knownIssues) since(repo string, fullSyncInterval time.Duration) (time.Time, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	r, ok := k.repositories[repo]
+	switch {
+	case ok && r.baseline != nil:
+		return r.baseline.newest, true
+	case !ok || !r.listed || time.Since(r.lastFullSync) > fullSyncInterval:
+		return time.Time{}, true
+	}
+	return r.newest, false
+}
+
+// merge updates the known issues of the repository with the ones updated since the time returned by since, and
+// returns all of them. complete is true if the listing got all of these issues. It returns false while the
+// first full listing of the repository is in progress, since the known issues are not all the issues yet.
+func (k *knownIssues) merge(repo string, issues []githubIssue, full, complete bool, fetchedAt time.Time) ([]githubIssue, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.repositories == nil {
+		k.repositories = make(map[string]*repositoryIssues)
+	}
+	r, ok := k.repositories[repo]
+	if !ok {
+		r = &repositoryIssues{issues: make(map[int64]githubIssue, len(issues))}
+		k.repositories[repo] = r
+	}
+	r.newest = addIssues(r.issues, issues, r.newest)
+
+	if full {
+		if r.baseline == nil {
+			r.baseline = &repositoryBaseline{issues: make(map[int64]githubIssue, len(issues)), startedAt: fetchedAt}
+		}
+		r.baseline.newest = addIssues(r.baseline.issues, issues, r.baseline.newest)
+		if complete {
+			// the issues missing from the full listing were deleted or transferred
+			r.issues = r.baseline.issues
+			r.newest = r.baseline.newest
+			r.lastFullSync = r.baseline.startedAt
+			r.listed = true
+			r.baseline = nil
+		}
+	}
+	if !r.listed {
+		return nil, false
+	}
+
+	all := make([]githubIssue, 0, len(r.issues))
+	for _, i := range r.issues {
+		all = append(all, i)
+	}
+	return all, true
+}
+
+// addIssues adds the issues to known, and returns the newest update time among newest and the ones of the issues
+func addIssues(known map[int64]githubIssue, issues []githubIssue, newest time.Time) time.Time {
+	for _, i := range issues {
+		known[i.Number] = i
+		if i.UpdatedAt.After(newest) {
+			newest = i.UpdatedAt
+		}
+	}
+	return newest
+}
@@ -0,0 +1,160 @@
+package gclient_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github client incremental polling", func() {
+	var (
+		ts       *httptest.Server
+		sinces   []string
+		response string
+		// responses and more, when set, are the response and whether it has a next page for each since
+		responses map[string]string
+		more      map[string]bool
+	)
+
+	BeforeEach(func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		sinces = nil
+		responses = nil
+		more = nil
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			since := r.URL.Query().Get("since")
+			sinces = append(sinces, since)
+			if responses == nil {
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, response)
+				return
+			}
+			Expect(r.URL.Query().Get("sort")).To(Equal("updated"))
+			Expect(r.URL.Query().Get("direction")).To(Equal("asc"))
+			if more[since] {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, ts.URL, r.URL.Path))
+			}
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, responses[since])
+		}))
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("requests only the issues updated since the newest known one", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1}
+
+		response = `[
+			{"number": 1, "title": "issue 1 title", "state": "open", "updated_at": "2022-11-01T10:00:00Z"},
+			{"number": 2, "title": "issue 2 title", "state": "open", "updated_at": "2022-11-02T10:00:00Z"}
+		]`
+		tickets, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(tickets).To(HaveLen(2))
+
+		response = `[
+			{"number": 2, "title": "issue 2 title", "state": "closed", "updated_at": "2022-11-03T10:00:00Z"},
+			{"number": 10, "title": "PR", "body": "Fixes #1", "state": "open", "updated_at": "2022-11-03T11:00:00Z",
+			 "pull_request": {"diff_url": "just a field to have non-empty pull_request field"}}
+		]`
+		tickets, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(tickets).To(ConsistOf(
+			gclient.GithubTicket{Number: 1, Title: "issue 1 title", State: "open"},
+			gclient.GithubTicket{Number: 2, Title: "issue 2 title", State: "closed"},
+		))
+
+		response = `[]`
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+
+		Expect(sinces).To(Equal([]string{"", "2022-11-02T10:00:00Z", "2022-11-03T11:00:00Z"}))
+	})
+
+	It("lists all the issues again after the full sync interval", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, FullSyncInterval: time.Nanosecond}
+
+		response = `[{"number": 1, "title": "issue 1 title", "state": "open", "updated_at": "2022-11-01T10:00:00Z"}]`
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+
+		// issue 1 was deleted
+		response = `[{"number": 2, "title": "issue 2 title", "state": "open", "updated_at": "2022-11-02T10:00:00Z"}]`
+		tickets, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(tickets).To(ConsistOf(gclient.GithubTicket{Number: 2, Title: "issue 2 title", State: "open"}))
+		Expect(sinces).To(Equal([]string{"", ""}))
+	})
+
+	It("resumes the first listing that exceeds the maximum number of pages", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, MaxPages: 1}
+
+		responses = map[string]string{
+			"": `[
+				{"number": 1, "title": "issue 1 title", "state": "open", "updated_at": "2022-11-01T10:00:00Z"},
+				{"number": 2, "title": "issue 2 title", "state": "open", "updated_at": "2022-11-02T10:00:00Z"}
+			]`,
+			"2022-11-02T10:00:00Z": `[
+				{"number": 2, "title": "issue 2 title", "state": "open", "updated_at": "2022-11-02T10:00:00Z"},
+				{"number": 3, "title": "issue 3 title", "state": "open", "updated_at": "2022-11-03T10:00:00Z"}
+			]`,
+		}
+		more = map[string]bool{"": true}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(MatchError(gclient.ErrTransient))
+
+		tickets, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(tickets).To(ConsistOf(
+			gclient.GithubTicket{Number: 1, Title: "issue 1 title", State: "open"},
+			gclient.GithubTicket{Number: 2, Title: "issue 2 title", State: "open"},
+			gclient.GithubTicket{Number: 3, Title: "issue 3 title", State: "open"},
+		))
+		Expect(sinces).To(Equal([]string{"", "2022-11-02T10:00:00Z"}))
+	})
+
+	It("serves the known issues while the full sync is resumed", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, MaxPages: 1, FullSyncInterval: time.Nanosecond}
+
+		responses = map[string]string{"": `[
+			{"number": 1, "title": "issue 1 title", "state": "open", "updated_at": "2022-11-01T10:00:00Z"},
+			{"number": 4, "title": "issue 4 title", "state": "open", "updated_at": "2022-11-04T10:00:00Z"}
+		]`}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+
+		// issue 4 was deleted
+		responses = map[string]string{
+			"": `[{"number": 1, "title": "issue 1 title", "state": "open", "updated_at": "2022-11-01T10:00:00Z"}]`,
+			"2022-11-01T10:00:00Z": `[
+				{"number": 1, "title": "issue 1 title", "state": "open", "updated_at": "2022-11-01T10:00:00Z"},
+				{"number": 2, "title": "issue 2 title", "state": "open", "updated_at": "2022-11-05T10:00:00Z"}
+			]`,
+		}
+		more = map[string]bool{"": true}
+		tickets, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(tickets).To(ConsistOf(
+			gclient.GithubTicket{Number: 1, Title: "issue 1 title", State: "open"},
+			gclient.GithubTicket{Number: 4, Title: "issue 4 title", State: "open"},
+		))
+
+		tickets, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(tickets).To(ConsistOf(
+			gclient.GithubTicket{Number: 1, Title: "issue 1 title", State: "open"},
+			gclient.GithubTicket{Number: 2, Title: "issue 2 title", State: "open"},
+		))
+		Expect(sinces).To(Equal([]string{"", "", "2022-11-01T10:00:00Z"}))
+	})
+})
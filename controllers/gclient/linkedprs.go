@@ -0,0 +1,448 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// States of a linked PR
+const (
+	PR_STATE_OPEN   string = "open"
+	PR_STATE_CLOSED string = "closed"
+	PR_STATE_MERGED string = "merged"
+)
+
+// Review decisions of a linked PR, summarizing the latest review of each reviewer
+const (
+	REVIEW_APPROVED          string = "APPROVED"
+	REVIEW_CHANGES_REQUESTED string = "CHANGES_REQUESTED"
+	REVIEW_REQUIRED          string = "REVIEW_REQUIRED"
+)
+
+// Conclusions of the CI checks of a linked PR, combining the commit statuses and the check runs of its head commit
+const (
+	CHECKS_SUCCESS string = "success"
+	CHECKS_FAILURE string = "failure"
+	CHECKS_PENDING string = "pending"
+)
+
+// LinkedPR is a pull request linked to an issue
+type LinkedPR struct {
+	Number int64
+	// RepositoryURL is the API URL of the repository of the PR, which can differ from the one of the issue
+	RepositoryURL string
+	HTMLURL       string
+	// State is one of PR_STATE_OPEN, PR_STATE_CLOSED and PR_STATE_MERGED, or empty if the PR can't be accessed
+	State  string
+	Draft  bool
+	Author string
+	// ReviewDecision is one of the REVIEW_XXX values, or empty if the PR is closed
+	ReviewDecision string
+	// HeadSHA is the commit at the head of the PR branch
+	HeadSHA string
+	// Checks is one of the CHECKS_XXX values, or empty if the head commit has no checks or the PR is closed
+	Checks string
+	// MergeCommitSHA and MergedAt are set when the PR is merged
+	MergeCommitSHA string
+	MergedAt       time.Time
+}
+
+type githubPullRequest struct {
+	Number  int64  `json:"number"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+	Draft   bool   `json:"draft"`
+	Merged  bool   `json:"merged"`
+	User    struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head struct {
+		SHA string `json:"sha"`
+	} `json:"head"`
+	Base struct {
+		Repo struct {
+			URL string `json:"url"`
+		} `json:"repo"`
+	} `json:"base"`
+	MergeCommitSHA string     `json:"merge_commit_sha"`
+	MergedAt       *time.Time `json:"merged_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type githubCombinedStatus struct {
+	State      string `json:"state"`
+	TotalCount int    `json:"total_count"`
+}
+
+type githubCheckRuns struct {
+	CheckRuns []struct {
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+	} `json:"check_runs"`
+}
+
+type githubReview struct {
+	State string `json:"state"`
+	User  struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+type githubTimelineEvent struct {
+	Event  string `json:"event"`
+	Source struct {
+		Type  string      `json:"type"`
+		Issue githubIssue `json:"issue"`
+	} `json:"source"`
+	// CommitID and CommitURL are set by the "referenced" events
+	CommitID  string `json:"commit_id"`
+	CommitURL string `json:"commit_url"`
+}
+
+// linkedPRsQuery lists the PRs linked to the issue in its Development section, from the sidebar or with a
+// closing keyword
+const linkedPRsQuery = `query($owner: String!, $name: String!, $number: Int!, $cursor: String) {
+  repository(owner: $owner, name: $name) {
+    issue(number: $number) {
+      closedByPullRequestsReferences(first: 100, after: $cursor, includeClosedPrs: true) {
+        nodes { number url repository { nameWithOwner } }
+        pageInfo { hasNextPage endCursor }
+      }
+    }
+  }
+}`
+
+type githubLinkedPRsData struct {
+	Repository *struct {
+		Issue *struct {
+			ClosedByPullRequestsReferences struct {
+				Nodes []struct {
+					Number     int64  `json:"number"`
+					URL        string `json:"url"`
+					Repository struct {
+						NameWithOwner string `json:"nameWithOwner"`
+					} `json:"repository"`
+				} `json:"nodes"`
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+			} `json:"closedByPullRequestsReferences"`
+		} `json:"issue"`
+	} `json:"repository"`
+}
+
+// GetLinkedPRs returns the PRs linked to the issue:
+//   - "cross-referenced" in the issue timeline by a PR, of any repository, that declares to close the issue
+//   - "referenced" in the issue timeline by a commit of a PR
+//   - linked in the Development section of the issue, from the sidebar or with a closing keyword, as listed by
+//     the GraphQL API, since the REST timeline does not tell which PR a "connected" event links
+func (g *GClient) GetLinkedPRs(ctx context.Context, repo string, number int64) ([]LinkedPR, error) {
+	requestUrl, err := g.getAPIBaseURL(repo)
+	if err != nil {
+		return nil, err
+	}
+	requestUrl += fmt.Sprintf("/issues/%d/timeline?per_page=%d", number, g.perPage())
+	repoPath := repositoryPath(repo)
+
+	// the PRs are returned in the order they are found
+	var prs []LinkedPR
+	linked := make(map[string]bool)
+	link := func(pr LinkedPR) {
+		if key := linkedPRKey(pr); !linked[key] {
+			linked[key] = true
+			prs = append(prs, pr)
+		}
+	}
+
+	var commitURLs []string
+	err = g.getAllPages(ctx, repo, requestUrl, func(body io.Reader) error {
+		var events []githubTimelineEvent
+		if err := json.NewDecoder(body).Decode(&events); err != nil {
+			return err
+		}
+		for _, e := range events {
+			switch e.Event {
+			case "cross-referenced":
+				source := e.Source.Issue
+				if len(source.PullRequest) != 0 &&
+					closesIssue(source.Body, repositoryPath(source.RepositoryURL), repoPath, number) {
+					link(LinkedPR{Number: source.Number, RepositoryURL: source.RepositoryURL, HTMLURL: source.HTMLURL})
+				}
+			case "referenced":
+				if e.CommitID != "" && e.CommitURL != "" {
+					commitURLs = append(commitURLs, e.CommitURL)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, commitURL := range commitURLs {
+		commitPRs, err := g.getCommitPRs(ctx, commitURL)
+		if isInaccessible(err) {
+			log.FromContext(ctx).Info("could not access linked commit", "url", commitURL, "err", err.Error())
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, pr := range commitPRs {
+			link(pr)
+		}
+	}
+
+	developmentPRs, err := g.getDevelopmentPRs(ctx, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	for _, pr := range developmentPRs {
+		link(pr)
+	}
+
+	for i := range prs {
+		linked := prs[i]
+		if err := g.getPRDetails(ctx, &prs[i]); err != nil {
+			if !isInaccessible(err) {
+				return nil, err
+			}
+			// the PR is in a repository that the credentials can't access, e.g. a private or deleted fork
+			log.FromContext(ctx).Info("could not access linked PR", "url", linked.HTMLURL, "err", err.Error())
+			prs[i] = linked
+		}
+	}
+	return prs, nil
+}
+
+// isInaccessible tells if the request failed because the credentials can't access the repository
+func isInaccessible(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) || errors.Is(err, ErrUnauthorized)
+}
+
+// getCommitPRs returns the PRs that contain the commit, given the API URL of the commit
+func (g *GClient) getCommitPRs(ctx context.Context, commitURL string) ([]LinkedPR, error) {
+	// the credentials are selected by the API URL of the repository of the commit
+	repoUrl, _, found := strings.Cut(commitURL, "/commits/")
+	if !found {
+		return nil, fmt.Errorf("could not recognize commit URL %s: %w", commitURL, ErrValidation)
+	}
+	var prs []LinkedPR
+	requestUrl := fmt.Sprintf("%s/pulls?per_page=%d", commitURL, g.perPage())
+	err := g.getAllPages(ctx, repoUrl, requestUrl, func(body io.Reader) error {
+		var pulls []githubPullRequest
+		if err := json.NewDecoder(body).Decode(&pulls); err != nil {
+			return err
+		}
+		for _, pull := range pulls {
+			prs = append(prs, LinkedPR{Number: pull.Number, RepositoryURL: pull.Base.Repo.URL, HTMLURL: pull.HTMLURL})
+		}
+		return nil
+	})
+	return prs, err
+}
+
+// getDevelopmentPRs returns the PRs linked in the Development section of the issue
+func (g *GClient) getDevelopmentPRs(ctx context.Context, repo string, number int64) ([]LinkedPR, error) {
+	repoUrl, err := url.Parse(repo)
+	if err != nil {
+		return nil, fmt.Errorf("could not recognize repository URL: %v\n", err)
+	}
+	owner, name, _ := strings.Cut(repositoryPath(repo), "/")
+	variables := map[string]interface{}{"owner": owner, "name": name, "number": number}
+
+	var prs []LinkedPR
+	for page := 0; page < g.maxPages(); page++ {
+		var data githubLinkedPRsData
+		if err := g.sendGraphQL(ctx, repo, linkedPRsQuery, variables, &data); err != nil {
+			return nil, err
+		}
+		if data.Repository == nil || data.Repository.Issue == nil {
+			// the number belongs to a PR, not to an issue
+			return nil, nil
+		}
+		references := data.Repository.Issue.ClosedByPullRequestsReferences
+		for _, node := range references.Nodes {
+			// the linked PRs are on the same host as the issue
+			prRepo, err := g.getAPIBaseURL(fmt.Sprintf("%s://%s/%s", repoUrl.Scheme, repoUrl.Host, node.Repository.NameWithOwner))
+			if err != nil {
+				return nil, err
+			}
+			prs = append(prs, LinkedPR{Number: node.Number, RepositoryURL: prRepo, HTMLURL: node.URL})
+		}
+		if !references.PageInfo.HasNextPage {
+			break
+		}
+		variables["cursor"] = references.PageInfo.EndCursor
+	}
+	return prs, nil
+}
+
+// getPRDetails completes the linked PR with the details that are only in the pulls API: draft and merged
+// state, author and review decision
+func (g *GClient) getPRDetails(ctx context.Context, pr *LinkedPR) error {
+	requestUrl := fmt.Sprintf("%s/pulls/%d", pr.RepositoryURL, pr.Number)
+	res, err := g.sendRequest(ctx, pr.RepositoryURL, "GET", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return newResponseError(requestUrl, res)
+	}
+	var pull githubPullRequest
+	if err := json.NewDecoder(res.Body).Decode(&pull); err != nil {
+		return fmt.Errorf("can't decode body: %v", err)
+	}
+
+	pr.HTMLURL = pull.HTMLURL
+	pr.State = pull.State
+	if pull.Merged {
+		pr.State = PR_STATE_MERGED
+		// merge_commit_sha is also set for open PRs, as the commit of the test merge
+		pr.MergeCommitSHA = pull.MergeCommitSHA
+		if pull.MergedAt != nil {
+			pr.MergedAt = *pull.MergedAt
+		}
+	}
+	pr.Draft = pull.Draft
+	pr.Author = pull.User.Login
+	pr.HeadSHA = pull.Head.SHA
+
+	// the reviews and the checks matter only until the PR is closed
+	key := linkedPRKey(*pr)
+	if pull.State != PR_STATE_OPEN {
+		g.prs.forget(key)
+		return nil
+	}
+
+	if decision, ok := g.prs.reviewDecision(key, pr.HeadSHA, pull.UpdatedAt); ok {
+		pr.ReviewDecision = decision
+	} else {
+		var reviews []githubReview
+		err = g.getAllPages(ctx, pr.RepositoryURL, fmt.Sprintf("%s/reviews?per_page=%d", requestUrl, g.perPage()), func(body io.Reader) error {
+			var page []githubReview
+			if err := json.NewDecoder(body).Decode(&page); err != nil {
+				return err
+			}
+			reviews = append(reviews, page...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		pr.ReviewDecision = reviewDecision(reviews)
+	}
+
+	// the checks requests are conditional, see responseCache
+	if pr.HeadSHA != "" {
+		pr.Checks, err = g.getChecks(ctx, pr.RepositoryURL, pr.HeadSHA)
+		if err != nil {
+			return err
+		}
+	}
+	g.prs.update(key, *pr, pull.UpdatedAt)
+	return nil
+}
+
+// getChecks combines the commit statuses and the check runs of the commit: failed if any of them failed,
+// pending if any of them did not complete, successful otherwise. It is empty if the commit has no checks.
+func (g *GClient) getChecks(ctx context.Context, repoUrl, sha string) (string, error) {
+	var failed, pending, succeeded bool
+
+	requestUrl := fmt.Sprintf("%s/commits/%s/status", repoUrl, sha)
+	res, err := g.sendRequest(ctx, repoUrl, "GET", requestUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", newResponseError(requestUrl, res)
+	}
+	var status githubCombinedStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return "", fmt.Errorf("can't decode body: %v", err)
+	}
+	// the combined state is pending also when there are no statuses at all
+	if status.TotalCount > 0 {
+		switch status.State {
+		case "success":
+			succeeded = true
+		case "pending":
+			pending = true
+		default:
+			failed = true
+		}
+	}
+
+	requestUrl = fmt.Sprintf("%s/commits/%s/check-runs?per_page=%d", repoUrl, sha, g.perPage())
+	err = g.getAllPages(ctx, repoUrl, requestUrl, func(body io.Reader) error {
+		var page githubCheckRuns
+		if err := json.NewDecoder(body).Decode(&page); err != nil {
+			return err
+		}
+		for _, run := range page.CheckRuns {
+			if run.Status != "completed" {
+				pending = true
+				continue
+			}
+			switch run.Conclusion {
+			case "success", "neutral", "skipped":
+				succeeded = true
+			default:
+				failed = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case failed:
+		return CHECKS_FAILURE, nil
+	case pending:
+		return CHECKS_PENDING, nil
+	case succeeded:
+		return CHECKS_SUCCESS, nil
+	}
+	return "", nil
+}
+
+// reviewDecision summarizes the latest approval or change request of each reviewer, the reviews being in
+// chronological order. A change request wins over the approvals.
+func reviewDecision(reviews []githubReview) string {
+	latest := make(map[string]string)
+	for _, r := range reviews {
+		switch r.State {
+		case REVIEW_APPROVED, REVIEW_CHANGES_REQUESTED:
+			latest[r.User.Login] = r.State
+		case "DISMISSED":
+			delete(latest, r.User.Login)
+		}
+	}
+
+	decision := REVIEW_REQUIRED
+	for _, state := range latest {
+		if state == REVIEW_CHANGES_REQUESTED {
+			return REVIEW_CHANGES_REQUESTED
+		}
+		decision = REVIEW_APPROVED
+	}
+	return decision
+}
+
+func linkedPRKey(pr LinkedPR) string {
+	return fmt.Sprintf("%s#%d", repositoryKey(pr.RepositoryURL), pr.Number)
+}
@@ -0,0 +1,236 @@
+package gclient_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github client linked PRs", func() {
+	var ts *httptest.Server
+	var requests map[string]int
+	var sha14Status string
+
+	BeforeEach(func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		requests = make(map[string]int)
+		sha14Status = "failure"
+		var handler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+			requests[r.URL.Path]++
+			switch {
+			case r.URL.Path == "/owner/repo/issues/3/timeline":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprintf(w, `[
+					{"event": "labeled"},
+					{"event": "cross-referenced", "source": {"type": "issue", "issue": {
+						"number": 10, "body": "only mentions #3", "repository_url": "%[1]s/owner/repo",
+						"pull_request": {"url": "%[1]s/owner/repo/pulls/10"}}}},
+					{"event": "cross-referenced", "source": {"type": "issue", "issue": {
+						"number": 11, "body": "Fixes #3", "repository_url": "%[1]s/owner/repo",
+						"pull_request": {"url": "%[1]s/owner/repo/pulls/11"}}}},
+					{"event": "cross-referenced", "source": {"type": "issue", "issue": {
+						"number": 12, "body": "Fixes #3", "repository_url": "%[1]s/other/repo",
+						"pull_request": {"url": "%[1]s/other/repo/pulls/12"}}}},
+					{"event": "cross-referenced", "source": {"type": "issue", "issue": {
+						"number": 13, "body": "Closes owner/repo#3", "repository_url": "%[1]s/other/repo",
+						"pull_request": {"url": "%[1]s/other/repo/pulls/13"}}}},
+					{"event": "connected", "actor": {"login": "carol"}, "commit_id": null, "commit_url": null,
+						"created_at": "2022-11-02T10:00:00Z"},
+					{"event": "connected", "actor": {"login": "carol"}, "commit_id": null, "commit_url": null,
+						"created_at": "2022-11-03T10:00:00Z"},
+					{"event": "disconnected", "actor": {"login": "carol"}, "commit_id": null, "commit_url": null,
+						"created_at": "2022-11-04T10:00:00Z"},
+					{"event": "referenced", "actor": {"login": "frank"}, "commit_id": "abc",
+						"commit_url": "%[1]s/owner/repo/commits/abc", "created_at": "2022-11-04T11:00:00Z"}
+				]`, ts.URL)
+			case r.URL.Path == "/owner/repo/issues/5/timeline":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprintf(w, `[
+					{"event": "cross-referenced", "source": {"type": "issue", "issue": {
+						"number": 15, "body": "Fixes owner/repo#5", "repository_url": "%[1]s/private/repo",
+						"html_url": "https://github.com/private/repo/pull/15",
+						"pull_request": {"url": "%[1]s/private/repo/pulls/15"}}}},
+					{"event": "referenced", "actor": {"login": "frank"}, "commit_id": "def",
+						"commit_url": "%[1]s/private/repo/commits/def", "created_at": "2022-11-04T11:00:00Z"}
+				]`, ts.URL)
+			case strings.HasPrefix(r.URL.Path, "/private/repo/"):
+				// the repository is not visible with the credentials
+				w.WriteHeader(http.StatusNotFound)
+			case r.URL.Path == "/owner/repo/commits/abc/pulls":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprintf(w, `[{"number": 16, "html_url": "https://github.com/owner/repo/pull/16", "state": "open",
+					"user": {"login": "frank"}, "head": {"sha": "sha16"}, "base": {"repo": {"url": "%s/owner/repo"}}}]`, ts.URL)
+			case r.URL.Path == "/graphql":
+				// the Development section lists the PRs linked from the sidebar and with a closing keyword
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"data": {"repository": {"issue": {"closedByPullRequestsReferences": {
+					"nodes": [
+						{"number": 11, "url": "https://github.com/owner/repo/pull/11",
+							"repository": {"nameWithOwner": "owner/repo"}},
+						{"number": 14, "url": "https://github.com/owner/repo/pull/14",
+							"repository": {"nameWithOwner": "owner/repo"}}
+					],
+					"pageInfo": {"hasNextPage": false, "endCursor": "Y3Vyc29yOjI="}
+				}}}}}`)
+			case r.URL.Path == "/owner/repo/pulls/11":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"number": 11, "html_url": "https://github.com/owner/repo/pull/11", "state": "open",
+					"draft": true, "user": {"login": "alice"}, "head": {"sha": "sha11"}}`)
+			case r.URL.Path == "/other/repo/pulls/13":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"number": 13, "html_url": "https://github.com/other/repo/pull/13", "state": "closed",
+					"merged": true, "user": {"login": "bob"}, "head": {"sha": "sha13"},
+					"merge_commit_sha": "merge13", "merged_at": "2022-11-05T10:00:00Z"}`)
+			case r.URL.Path == "/owner/repo/pulls/14":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"number": 14, "html_url": "https://github.com/owner/repo/pull/14", "state": "open",
+					"user": {"login": "carol"}, "head": {"sha": "sha14"},
+					"merge_commit_sha": "test-merge14", "merged_at": null}`)
+			case r.URL.Path == "/owner/repo/pulls/16":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"number": 16, "html_url": "https://github.com/owner/repo/pull/16", "state": "open",
+					"user": {"login": "frank"}, "head": {"sha": "sha16"}}`)
+			case r.URL.Path == "/owner/repo/pulls/16/reviews":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `[{"state": "APPROVED", "user": {"login": "dave"}}]`)
+			case r.URL.Path == "/owner/repo/commits/sha16/status":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"state": "success", "total_count": 1}`)
+			case r.URL.Path == "/owner/repo/commits/sha16/check-runs":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"total_count": 0, "check_runs": []}`)
+			case r.URL.Path == "/owner/repo/pulls/11/reviews":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `[]`)
+			case r.URL.Path == "/owner/repo/pulls/14/reviews":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `[
+					{"state": "APPROVED", "user": {"login": "dave"}},
+					{"state": "CHANGES_REQUESTED", "user": {"login": "erin"}}
+				]`)
+			case r.URL.Path == "/owner/repo/commits/sha11/status":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"state": "success", "total_count": 1}`)
+			case r.URL.Path == "/owner/repo/commits/sha11/check-runs":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"total_count": 2, "check_runs": [
+					{"status": "completed", "conclusion": "success"},
+					{"status": "in_progress"}
+				]}`)
+			case r.URL.Path == "/owner/repo/commits/sha14/status":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprintf(w, `{"state": "%s", "total_count": 2}`, sha14Status)
+			case r.URL.Path == "/owner/repo/commits/sha14/check-runs":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"total_count": 0, "check_runs": []}`)
+			case r.URL.Path == "/repos/owner/repo/installation" || r.URL.Path == "/repos/other/repo/installation":
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"id": 7}`)
+			case r.URL.Path == "/app/installations/7/access_tokens":
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"token": "installation token", "expires_at": "%s"}`,
+					time.Now().Add(time.Hour).Format(time.RFC3339))
+			case strings.HasSuffix(r.URL.Path, "/timeline"):
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}
+		ts = httptest.NewServer(handler)
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("finds the PRs linked in the issue timeline and in the Development section", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		prs, err := underTest.GetLinkedPRs(context.TODO(), "https://github.com/owner/repo", 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(prs).To(Equal([]gclient.LinkedPR{
+			{
+				Number: 11, RepositoryURL: ts.URL + "/owner/repo", HTMLURL: "https://github.com/owner/repo/pull/11",
+				State: gclient.PR_STATE_OPEN, Draft: true, Author: "alice", ReviewDecision: gclient.REVIEW_REQUIRED,
+				HeadSHA: "sha11", Checks: gclient.CHECKS_PENDING,
+			},
+			{
+				Number: 13, RepositoryURL: ts.URL + "/other/repo", HTMLURL: "https://github.com/other/repo/pull/13",
+				State: gclient.PR_STATE_MERGED, Author: "bob", HeadSHA: "sha13",
+				MergeCommitSHA: "merge13", MergedAt: time.Date(2022, 11, 5, 10, 0, 0, 0, time.UTC),
+			},
+			{
+				Number: 16, RepositoryURL: ts.URL + "/owner/repo", HTMLURL: "https://github.com/owner/repo/pull/16",
+				State: gclient.PR_STATE_OPEN, Author: "frank", ReviewDecision: gclient.REVIEW_APPROVED,
+				HeadSHA: "sha16", Checks: gclient.CHECKS_SUCCESS,
+			},
+			{
+				Number: 14, RepositoryURL: ts.URL + "/owner/repo", HTMLURL: "https://github.com/owner/repo/pull/14",
+				State: gclient.PR_STATE_OPEN, Author: "carol", ReviewDecision: gclient.REVIEW_CHANGES_REQUESTED,
+				HeadSHA: "sha14", Checks: gclient.CHECKS_FAILURE,
+			},
+		}))
+	})
+
+	It("requests the reviews again only when they can have changed, and the checks every time", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		prs, err := underTest.GetLinkedPRs(context.TODO(), "https://github.com/owner/repo", 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(prs).To(ContainElement(And(HaveField("Number", int64(14)), HaveField("Checks", gclient.CHECKS_FAILURE))))
+
+		// the failed checks are re-run on the same head commit
+		sha14Status = "success"
+		prs, err = underTest.GetLinkedPRs(context.TODO(), "https://github.com/owner/repo", 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(prs).To(ContainElement(And(HaveField("Number", int64(14)), HaveField("Checks", gclient.CHECKS_SUCCESS))))
+
+		Expect(requests).To(And(
+			HaveKeyWithValue("/owner/repo/pulls/14", 2),
+			// neither the head commit nor the update time of the PR changed
+			HaveKeyWithValue("/owner/repo/pulls/14/reviews", 1),
+			HaveKeyWithValue("/owner/repo/commits/sha14/status", 2),
+			HaveKeyWithValue("/owner/repo/commits/sha14/check-runs", 2),
+			// the PR is merged
+			Not(HaveKey("/other/repo/pulls/13/reviews")),
+			Not(HaveKey("/other/repo/commits/sha13/status")),
+		))
+	})
+
+	It("selects the Github App installation of the repository of the commits", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+		credentials := &gclient.AppTokenSource{APIURL: ts.URL, AppID: 42, PrivateKey: key,
+			Hosts: gclient.Hosts{"github.example.com": ts.URL}}
+		underTest := gclient.GClient{BaseURL: ts.URL, Credentials: credentials}
+		prs, err := underTest.GetLinkedPRs(context.TODO(), "https://github.com/owner/repo", 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(prs).To(ContainElement(HaveField("Number", int64(16))))
+		Expect(requests).ToNot(HaveKey(HavePrefix("/repos/commits/")))
+	})
+
+	It("links the PRs that can't be accessed without their details", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		prs, err := underTest.GetLinkedPRs(context.TODO(), "https://github.com/owner/repo", 5)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(prs).To(ContainElement(gclient.LinkedPR{
+			Number: 15, RepositoryURL: ts.URL + "/private/repo", HTMLURL: "https://github.com/private/repo/pull/15",
+		}))
+		Expect(prs).To(ContainElement(HaveField("Number", int64(14))))
+	})
+
+	It("returns the errors of the timeline requests", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		_, err := underTest.GetLinkedPRs(context.TODO(), "https://github.com/owner/repo", 4)
+		Expect(err).To(MatchError(gclient.ErrNotFound))
+	})
+})
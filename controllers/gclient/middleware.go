@@ -0,0 +1,167 @@
+package gclient
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DEFAULT_USER_AGENT is the User-Agent of the requests sent to Github
+const DEFAULT_USER_AGENT string = "githubissues-operator"
+
+// GITHUB_API_VERSION is the version of the Github REST API the client is written for
+const GITHUB_API_VERSION string = "2022-11-28"
+
+// DEFAULT_RETRIES is the number of times an idempotent request is retried after a transient failure
+const DEFAULT_RETRIES int = 2
+
+// DEFAULT_RETRY_DELAY is the delay before the first retry, doubled at every following one
+const DEFAULT_RETRY_DELAY time.Duration = 500 * time.Millisecond
+
+// Middleware wraps the RoundTripper sending the requests to Github, e.g. to observe or modify them
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to http.RoundTripper
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain wraps transport with the middlewares, the first middleware being the outermost. http.DefaultTransport
+// is used if transport is nil.
+func Chain(transport http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		transport = middlewares[i](transport)
+	}
+	return transport
+}
+
+// DefaultMiddlewares returns the built-in middlewares: the Github headers, the retry of the idempotent requests,
+// and the logging of every attempt
+func DefaultMiddlewares() []Middleware {
+	return []Middleware{
+		Headers(DEFAULT_USER_AGENT, GITHUB_API_VERSION),
+		Retry(DEFAULT_RETRIES, DEFAULT_RETRY_DELAY),
+		Logging(),
+	}
+}
+
+// Headers sets the User-Agent and the X-GitHub-Api-Version headers, unless the request already has them
+func Headers(userAgent, apiVersion string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			if req.Header.Get("User-Agent") == "" {
+				req.Header.Set("User-Agent", userAgent)
+			}
+			if req.Header.Get("X-GitHub-Api-Version") == "" {
+				req.Header.Set("X-GitHub-Api-Version", apiVersion)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// Logging logs every request, with the Authorization header redacted, at debug level with the logger of the
+// request context
+func Logging() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			l := log.FromContext(req.Context()).WithName("github").V(1)
+			start := time.Now()
+			res, err := next.RoundTrip(req)
+			keysAndValues := []interface{}{
+				"method", req.Method,
+				"url", req.URL.String(),
+				"headers", redactedHeaders(req.Header),
+				"duration", time.Since(start),
+			}
+			if err != nil {
+				l.Info("Github request failed", append(keysAndValues, "err", err.Error())...)
+				return nil, err
+			}
+			l.Info("Github request", append(keysAndValues,
+				"status", res.StatusCode,
+				"requestID", res.Header.Get("X-GitHub-Request-Id"))...)
+			return res, nil
+		})
+	}
+}
+
+// redactedHeaders returns the headers with the credentials hidden
+func redactedHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for name := range header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Proxy-Authorization", "Cookie":
+			redacted[name] = "REDACTED"
+		default:
+			redacted[name] = header.Get(name)
+		}
+	}
+	return redacted
+}
+
+// Retry retries the idempotent requests failed because of a network error or a temporarily unavailable Github
+// (502, 503, 504), up to retries times. The delay before each retry is doubled every time, and randomized
+// to avoid that many clients retry together. Rate limited requests are not retried here.
+func Retry(retries int, delay time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !isIdempotent(req.Method) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+				return next.RoundTrip(req)
+			}
+			for attempt := 0; ; attempt++ {
+				res, err := next.RoundTrip(req)
+				if attempt >= retries || !isRetriable(res, err) || req.Context().Err() != nil {
+					return res, err
+				}
+				if res != nil {
+					res.Body.Close()
+				}
+
+				wait := delay << attempt
+				wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(wait):
+				}
+
+				if req.GetBody != nil {
+					body, err := req.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					req = req.Clone(req.Context())
+					req.Body = body
+				}
+			}
+		})
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isRetriable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
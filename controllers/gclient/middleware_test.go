@@ -0,0 +1,113 @@
+package gclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github client middlewares", func() {
+	var (
+		ts        *httptest.Server
+		requests  []*http.Request
+		responses []int
+	)
+
+	BeforeEach(func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		requests = nil
+		responses = nil
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			status := http.StatusOK
+			if len(responses) > 0 {
+				status, responses = responses[0], responses[1:]
+			}
+			w.Header().Set("X-GitHub-Request-Id", "REQUEST-ID")
+			w.WriteHeader(status)
+			w.Write([]byte(`[]`))
+		}))
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("runs the middlewares in order", func() {
+		var calls []string
+		middleware := func(name string) gclient.Middleware {
+			return func(next http.RoundTripper) http.RoundTripper {
+				return gclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					calls = append(calls, name)
+					return next.RoundTrip(req)
+				})
+			}
+		}
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, Middlewares: []gclient.Middleware{
+			middleware("first"), middleware("second"),
+		}}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(Equal([]string{"first", "second"}))
+	})
+
+	It("sets the Github headers", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, Middlewares: []gclient.Middleware{
+			gclient.Headers("test-agent", gclient.GITHUB_API_VERSION),
+		}}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Header.Get("User-Agent")).To(Equal("test-agent"))
+		Expect(requests[0].Header.Get("X-GitHub-Api-Version")).To(Equal(gclient.GITHUB_API_VERSION))
+	})
+
+	It("logs the requests without the token", func() {
+		var logs []string
+		logger := funcr.New(func(prefix, args string) {
+			logs = append(logs, args)
+		}, funcr.Options{Verbosity: 1})
+		ctx := logr.NewContext(context.TODO(), logger)
+
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, Middlewares: []gclient.Middleware{gclient.Logging()}}
+		_, err := underTest.GetTickets(ctx, "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logs).To(HaveLen(1))
+		Expect(logs[0]).To(ContainSubstring(`"requestID"="REQUEST-ID"`))
+		Expect(logs[0]).To(ContainSubstring(`"Authorization":"REDACTED"`))
+		Expect(strings.Join(logs, "\n")).ToNot(ContainSubstring("fake github token"))
+	})
+
+	It("retries only the idempotent requests", func() {
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, Middlewares: []gclient.Middleware{
+			gclient.Retry(2, time.Millisecond),
+		}}
+
+		responses = []int{http.StatusServiceUnavailable, http.StatusBadGateway}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests).To(HaveLen(3))
+
+		requests = nil
+		responses = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(MatchError(gclient.ErrTransient))
+		Expect(requests).To(HaveLen(3))
+
+		requests = nil
+		responses = []int{http.StatusServiceUnavailable}
+		_, err = underTest.CreateTicket(context.TODO(), gclient.GithubTicket{RepositoryURL: "https://github.com/owner/repo"})
+		Expect(err).To(MatchError(gclient.ErrTransient))
+		Expect(requests).To(HaveLen(1))
+	})
+})
@@ -5,6 +5,7 @@
 package mock
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gclient "github.com/clobrano/githubissues-operator/controllers/gclient"
@@ -34,59 +35,104 @@ func (m *MockGithubClient) EXPECT() *MockGithubClientMockRecorder {
 	return m.recorder
 }
 
-// CreateTicket mocks base method.
-func (m *MockGithubClient) CreateTicket(arg0 gclient.GithubTicket) error {
+// CommentTicket mocks base method.
+func (m *MockGithubClient) CommentTicket(arg0 context.Context, arg1 gclient.GithubTicket, arg2 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateTicket", arg0)
+	ret := m.ctrl.Call(m, "CommentTicket", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// CommentTicket indicates an expected call of CommentTicket.
+func (mr *MockGithubClientMockRecorder) CommentTicket(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommentTicket", reflect.TypeOf((*MockGithubClient)(nil).CommentTicket), arg0, arg1, arg2)
+}
+
+// CreateTicket mocks base method.
+func (m *MockGithubClient) CreateTicket(arg0 context.Context, arg1 gclient.GithubTicket) (*gclient.GithubTicket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateTicket", arg0, arg1)
+	ret0, _ := ret[0].(*gclient.GithubTicket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // CreateTicket indicates an expected call of CreateTicket.
-func (mr *MockGithubClientMockRecorder) CreateTicket(arg0 interface{}) *gomock.Call {
+func (mr *MockGithubClientMockRecorder) CreateTicket(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTicket", reflect.TypeOf((*MockGithubClient)(nil).CreateTicket), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTicket", reflect.TypeOf((*MockGithubClient)(nil).CreateTicket), arg0, arg1)
+}
+
+// GetLinkedPRs mocks base method.
+func (m *MockGithubClient) GetLinkedPRs(arg0 context.Context, arg1 string, arg2 int64) ([]gclient.LinkedPR, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLinkedPRs", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]gclient.LinkedPR)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLinkedPRs indicates an expected call of GetLinkedPRs.
+func (mr *MockGithubClientMockRecorder) GetLinkedPRs(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLinkedPRs", reflect.TypeOf((*MockGithubClient)(nil).GetLinkedPRs), arg0, arg1, arg2)
+}
+
+// GetTicket mocks base method.
+func (m *MockGithubClient) GetTicket(arg0 context.Context, arg1 string, arg2 int64) (*gclient.GithubTicket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTicket", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*gclient.GithubTicket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTicket indicates an expected call of GetTicket.
+func (mr *MockGithubClientMockRecorder) GetTicket(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTicket", reflect.TypeOf((*MockGithubClient)(nil).GetTicket), arg0, arg1, arg2)
 }
 
 // GetTickets mocks base method.
-func (m *MockGithubClient) GetTickets(arg0 string) ([]gclient.GithubTicket, error) {
+func (m *MockGithubClient) GetTickets(arg0 context.Context, arg1 string) ([]gclient.GithubTicket, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetTickets", arg0)
+	ret := m.ctrl.Call(m, "GetTickets", arg0, arg1)
 	ret0, _ := ret[0].([]gclient.GithubTicket)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetTickets indicates an expected call of GetTickets.
-func (mr *MockGithubClientMockRecorder) GetTickets(arg0 interface{}) *gomock.Call {
+func (mr *MockGithubClientMockRecorder) GetTickets(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTickets", reflect.TypeOf((*MockGithubClient)(nil).GetTickets), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTickets", reflect.TypeOf((*MockGithubClient)(nil).GetTickets), arg0, arg1)
 }
 
-// IssueHasPR mocks base method.
-func (m *MockGithubClient) IssueHasPR(arg0 gclient.GithubTicket) bool {
+// LockTicket mocks base method.
+func (m *MockGithubClient) LockTicket(arg0 context.Context, arg1 gclient.GithubTicket) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "IssueHasPR", arg0)
-	ret0, _ := ret[0].(bool)
+	ret := m.ctrl.Call(m, "LockTicket", arg0, arg1)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// IssueHasPR indicates an expected call of IssueHasPR.
-func (mr *MockGithubClientMockRecorder) IssueHasPR(arg0 interface{}) *gomock.Call {
+// LockTicket indicates an expected call of LockTicket.
+func (mr *MockGithubClientMockRecorder) LockTicket(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IssueHasPR", reflect.TypeOf((*MockGithubClient)(nil).IssueHasPR), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockTicket", reflect.TypeOf((*MockGithubClient)(nil).LockTicket), arg0, arg1)
 }
 
 // UpdateTicket mocks base method.
-func (m *MockGithubClient) UpdateTicket(arg0 gclient.GithubTicket) error {
+func (m *MockGithubClient) UpdateTicket(arg0 context.Context, arg1, arg2 gclient.GithubTicket) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateTicket", arg0)
+	ret := m.ctrl.Call(m, "UpdateTicket", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateTicket indicates an expected call of UpdateTicket.
-func (mr *MockGithubClientMockRecorder) UpdateTicket(arg0 interface{}) *gomock.Call {
+func (mr *MockGithubClientMockRecorder) UpdateTicket(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTicket", reflect.TypeOf((*MockGithubClient)(nil).UpdateTicket), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTicket", reflect.TypeOf((*MockGithubClient)(nil).UpdateTicket), arg0, arg1, arg2)
 }
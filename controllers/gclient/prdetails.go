@@ -0,0 +1,51 @@
+package gclient
+
+import (
+	"sync"
+	"time"
+)
+
+// knownPRs keeps the review decision of the open linked PRs, so that the reviews are requested again only when
+// the PR changes: a new review updates the PR, while the head commit is the same. The checks are not kept, since
+// they can be re-run, or new statuses posted, on the same commit: their requests are conditional instead.
+type knownPRs struct {
+	mu  sync.Mutex
+	prs map[string]knownPR
+}
+
+type knownPR struct {
+	headSHA        string
+	updatedAt      time.Time
+	reviewDecision string
+}
+
+// reviewDecision returns the known review decision of the PR, if it did not change
+func (k *knownPRs) reviewDecision(key, headSHA string, updatedAt time.Time) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	pr, ok := k.prs[key]
+	if !ok || pr.headSHA != headSHA || !pr.updatedAt.Equal(updatedAt) {
+		return "", false
+	}
+	return pr.reviewDecision, true
+}
+
+// update records the details of the open PR
+func (k *knownPRs) update(key string, pr LinkedPR, updatedAt time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.prs == nil {
+		k.prs = make(map[string]knownPR)
+	}
+	k.prs[key] = knownPR{headSHA: pr.HeadSHA, updatedAt: updatedAt, reviewDecision: pr.ReviewDecision}
+}
+
+// forget removes the PR, which is closed and won't be requested again
+func (k *knownPRs) forget(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	delete(k.prs, key)
+}
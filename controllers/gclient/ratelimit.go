@@ -0,0 +1,139 @@
+package gclient
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_RATE_LIMIT_WAIT is the longest delay a request can wait for the rate limit reset when GClient.RateLimitWait is not set
+const DEFAULT_RATE_LIMIT_WAIT time.Duration = 5 * time.Second
+
+// secondaryRateLimitWait is the delay Github suggests when a secondary rate limit response has no Retry-After header
+const secondaryRateLimitWait time.Duration = time.Minute
+
+// RateLimitedError is returned when a request was, or would be, refused by Github because the token exhausted its rate limit
+type RateLimitedError struct {
+	URL string
+	// Reset is the time when the token can be used again
+	Reset time.Time
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("request %s is rate limited until %s", e.URL, e.Reset.Format(time.RFC3339))
+}
+
+// Resources of the rate limit: the REST and the GraphQL APIs have separate budgets for each token
+const (
+	rateLimitResourceCore    string = "core"
+	rateLimitResourceGraphQL string = "graphql"
+)
+
+type rateLimit struct {
+	remaining int
+	reset     time.Time
+}
+
+type rateLimitKey struct {
+	token    string
+	resource string
+}
+
+// rateLimitTracker keeps track of the remaining request budget of each token, for each resource
+type rateLimitTracker struct {
+	mu     sync.Mutex
+	limits map[rateLimitKey]rateLimit
+}
+
+// exhaustedUntil returns the time when the token budget for the resource is reset, or the zero time if the
+// token can be used now
+func (t *rateLimitTracker) exhaustedUntil(token, resource string) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.limits[rateLimitKey{token: token, resource: resource}]
+	if !ok || l.remaining > 0 || !time.Now().Before(l.reset) {
+		return time.Time{}
+	}
+	return l.reset
+}
+
+// update records the token budget reported by a response for the resource of the request, unless the response
+// tells another one. If the response is a rate limit error (primary or secondary), it returns the time when the
+// request can be retried, otherwise the zero time.
+func (t *rateLimitTracker) update(token, resource string, res *http.Response) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.limits == nil {
+		t.limits = make(map[rateLimitKey]rateLimit)
+	}
+	if r := res.Header.Get("X-RateLimit-Resource"); r != "" {
+		resource = r
+	}
+	key := rateLimitKey{token: token, resource: resource}
+
+	remaining, errRemaining := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	resetUnix, errReset := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+	hasBudget := errRemaining == nil && errReset == nil
+	if hasBudget {
+		t.limits[key] = rateLimit{remaining: remaining, reset: time.Unix(resetUnix, 0)}
+	}
+
+	if !isRateLimited(res, hasBudget && remaining == 0) {
+		return time.Time{}
+	}
+
+	var reset time.Time
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		reset = time.Now().Add(time.Duration(seconds) * time.Second)
+	} else if hasBudget && remaining == 0 {
+		reset = time.Unix(resetUnix, 0)
+	} else {
+		reset = time.Now().Add(secondaryRateLimitWait)
+	}
+	t.limits[key] = rateLimit{remaining: 0, reset: reset}
+	return reset
+}
+
+// rateLimitResource returns the resource of the rate limit used by a request to the URL
+func rateLimitResource(requestUrl string) string {
+	if u, err := url.Parse(requestUrl); err == nil && strings.HasSuffix(u.Path, GITHUB_GRAPHQL_PATH) {
+		return rateLimitResourceGraphQL
+	}
+	return rateLimitResourceCore
+}
+
+// rateLimitReset returns the reset of the rate limit reported by the response, or the delay Github suggests if
+// there is none
+func rateLimitReset(res *http.Response) time.Time {
+	if resetUnix, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return time.Unix(resetUnix, 0)
+	}
+	return time.Now().Add(secondaryRateLimitWait)
+}
+
+// isRateLimited tells if the response is a primary or secondary rate limit error.
+// Github returns 403 also for permission errors, so for 403 look for rate limit hints in headers and body.
+func isRateLimited(res *http.Response, exhausted bool) bool {
+	switch res.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		if exhausted || res.Header.Get("Retry-After") != "" {
+			return true
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		// restore the body for the caller
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		return err == nil && strings.Contains(strings.ToLower(string(body)), "rate limit")
+	}
+	return false
+}
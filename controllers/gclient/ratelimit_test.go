@@ -0,0 +1,146 @@
+package gclient_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github client rate limit", func() {
+	var requests int
+
+	BeforeEach(func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+		requests = 0
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("stops sending requests until the rate limit reset", func() {
+		reset := time.Now().Add(time.Hour).Truncate(time.Second)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		var rateLimited *gclient.RateLimitedError
+		Expect(errors.As(err, &rateLimited)).To(BeTrue())
+		Expect(rateLimited.Reset).To(Equal(reset))
+		Expect(requests).To(Equal(1))
+
+		// the token budget is known to be exhausted, Github is not contacted at all
+		_, err = underTest.GetTicket(context.TODO(), "https://github.com/owner/repo", 1)
+		Expect(errors.As(err, &rateLimited)).To(BeTrue())
+		Expect(requests).To(Equal(1))
+	})
+
+	It("keeps the budgets of the REST and the GraphQL APIs apart", func() {
+		reset := time.Now().Add(time.Hour).Truncate(time.Second)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			if r.URL.Path == "/graphql" {
+				// Github reports the exhausted GraphQL budget in a successful response
+				w.Header().Set("X-RateLimit-Resource", "graphql")
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"errors": [{"type": "RATE_LIMITED", "message": "API rate limit exceeded"}]}`)
+				return
+			}
+			w.Header().Set("X-RateLimit-Resource", "core")
+			w.Header().Set("X-RateLimit-Remaining", "10")
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `[]`)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		_, err := underTest.GetLinkedPRs(context.TODO(), "https://github.com/owner/repo", 1)
+		var rateLimited *gclient.RateLimitedError
+		Expect(errors.As(err, &rateLimited)).To(BeTrue())
+		Expect(rateLimited.Reset).To(Equal(reset))
+		Expect(requests).To(Equal(2))
+
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests).To(Equal(3))
+
+		// the GraphQL budget is known to be exhausted, only the timeline is requested
+		_, err = underTest.GetLinkedPRs(context.TODO(), "https://github.com/owner/repo", 1)
+		Expect(errors.As(err, &rateLimited)).To(BeTrue())
+		Expect(requests).To(Equal(4))
+	})
+
+	It("retries the request after a secondary rate limit", func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `[{"number": 1, "title": "issue 1 title", "body": "issue 1 description", "state": "open"}]`)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		tickets, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(BeNil())
+		Expect(tickets).To(HaveLen(1))
+		Expect(requests).To(Equal(2))
+	})
+
+	It("detects secondary rate limits from the response body", func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		var rateLimited *gclient.RateLimitedError
+		Expect(errors.As(err, &rateLimited)).To(BeTrue())
+		Expect(rateLimited.Reset).To(BeTemporally(">", time.Now()))
+		Expect(requests).To(Equal(1))
+	})
+
+	It("does not consider other forbidden responses as rate limits", func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("X-RateLimit-Remaining", "4999")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "Resource not accessible by integration"}`)
+		}))
+		defer ts.Close()
+
+		underTest := gclient.GClient{BaseURL: ts.URL}
+		_, err := underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		var rateLimited *gclient.RateLimitedError
+		Expect(err).To(HaveOccurred())
+		Expect(errors.As(err, &rateLimited)).To(BeFalse())
+
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(HaveOccurred())
+		Expect(requests).To(Equal(2))
+	})
+})
@@ -0,0 +1,64 @@
+package gclient
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// closingReferenceRegexp matches Github closing keywords followed by an issue reference, in any of the forms
+// "KEYWORD #ISSUE", "KEYWORD OWNER/REPOSITORY#ISSUE" and "KEYWORD https://HOST/OWNER/REPOSITORY/issues/ISSUE".
+// Keywords are case insensitive and can be followed by a colon.
+var closingReferenceRegexp = regexp.MustCompile(`(?i)\b(?:close|closes|closed|fix|fixes|fixed|resolve|resolves|resolved)(?::\s*|\s+)` +
+	`(?:#([0-9]+)|([\w.-]+)/([\w.-]+)#([0-9]+)|https?://[^\s/]+/([\w.-]+)/([\w.-]+)/issues/([0-9]+))\b`)
+
+// IssueReference is an issue that a PR declares to close
+type IssueReference struct {
+	// Owner and Repository are empty when the issue belongs to the repository of the PR
+	Owner      string
+	Repository string
+	Number     int64
+}
+
+// RepositoryOf returns the repository ("owner/repository") of the referenced issue, given the repository of the PR
+func (r IssueReference) RepositoryOf(prRepo string) string {
+	if r.Owner == "" {
+		return strings.ToLower(prRepo)
+	}
+	return strings.ToLower(r.Owner + "/" + r.Repository)
+}
+
+// ExtractClosingReferences returns the issues that a PR body declares to close with the Github closing keywords
+func ExtractClosingReferences(body string) []IssueReference {
+	var references []IssueReference
+	for _, m := range closingReferenceRegexp.FindAllStringSubmatch(body, -1) {
+		var ref IssueReference
+		var number string
+		switch {
+		case m[1] != "":
+			number = m[1]
+		case m[4] != "":
+			ref.Owner, ref.Repository, number = m[2], m[3], m[4]
+		default:
+			ref.Owner, ref.Repository, number = m[5], m[6], m[7]
+		}
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			continue
+		}
+		ref.Number = n
+		references = append(references, ref)
+	}
+	return references
+}
+
+// closesIssue returns whether the body of a PR of prRepo declares to close the issue number of issueRepo.
+// Repositories are identified by "owner/repository".
+func closesIssue(body, prRepo, issueRepo string, number int64) bool {
+	for _, ref := range ExtractClosingReferences(body) {
+		if ref.Number == number && ref.RepositoryOf(prRepo) == strings.ToLower(issueRepo) {
+			return true
+		}
+	}
+	return false
+}
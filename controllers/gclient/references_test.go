@@ -0,0 +1,48 @@
+package gclient_test
+
+import (
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Closing references", func() {
+	It("parses the Github closing keywords", func() {
+		tt := []struct {
+			body string
+			want []gclient.IssueReference
+		}{
+			{"Fixes #12", []gclient.IssueReference{{Number: 12}}},
+			{"CLOSES: #3", []gclient.IssueReference{{Number: 3}}},
+			{"resolved #7.", []gclient.IssueReference{{Number: 7}}},
+			{"Fixes owner/repo#12", []gclient.IssueReference{{Owner: "owner", Repository: "repo", Number: 12}}},
+			{"Closes https://github.com/owner/my.repo/issues/5", []gclient.IssueReference{
+				{Owner: "owner", Repository: "my.repo", Number: 5},
+			}},
+			{"Fixes #1, resolves other/repo#2\nclose #3", []gclient.IssueReference{
+				{Number: 1}, {Owner: "other", Repository: "repo", Number: 2}, {Number: 3},
+			}},
+			// not closing keywords
+			{"Refs #12", nil},
+			{"unfixed #12", nil},
+			{"prefixes #12", nil},
+			{"Fixes#12", nil},
+			{"Fixes #12abc", nil},
+			{"Fixes https://github.com/owner/repo/pull/5", nil},
+		}
+
+		for _, tc := range tt {
+			Expect(gclient.ExtractClosingReferences(tc.body)).To(Equal(tc.want), tc.body)
+		}
+	})
+
+	It("resolves the repository of the references", func() {
+		Expect(gclient.IssueReference{Number: 1}.RepositoryOf("Owner/Repo")).To(Equal("owner/repo"))
+		Expect(gclient.IssueReference{Owner: "Other", Repository: "Repo", Number: 1}.RepositoryOf("owner/repo")).
+			To(Equal("other/repo"))
+	})
+
+	It("ignores the references to other repositories when listing the issues", func() {
+		Expect(gclient.ExtractReferencedIssue("Fixes #1, fixes other/repo#2")).To(Equal([]int{1}))
+	})
+})
@@ -0,0 +1,274 @@
+package gclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_SNAPSHOT_TTL is how long a repository snapshot is served by CachedClient
+const DEFAULT_SNAPSHOT_TTL time.Duration = 30 * time.Second
+
+// DEFAULT_OPERATION_TIMEOUT is the deadline of a call to the wrapped client when CachedClient.Timeout is not set.
+// A call can send many requests, e.g. all the pages of the issues of a large repository, and wait for the rate limit.
+const DEFAULT_OPERATION_TIMEOUT time.Duration = 10 * time.Minute
+
+// CachedClient is a GithubClient shared by all the GithubIssue resources. It keeps a snapshot of the tickets
+// of each repository for TTL, and coalesces concurrent requests for the same repository (or the same ticket)
+// into a single call to the wrapped client, so that the API usage scales with the repositories rather than with
+// the resources. The snapshot of a repository is invalidated when the operator creates or updates its tickets.
+type CachedClient struct {
+	Client GithubClient
+	TTL    time.Duration
+	// Timeout bounds the whole calls to Client shared by concurrent callers, DEFAULT_OPERATION_TIMEOUT if not set.
+	// Each request sent by Client has its own deadline.
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	snapshots map[string]*repositorySnapshot
+	linkedPRs map[string]*linkedPRsSnapshot
+	inflight  map[string]*inflightCall
+}
+
+type repositorySnapshot struct {
+	tickets   []GithubTicket
+	fetchedAt time.Time
+	// generation is incremented on every invalidation, to discard fetches started before it
+	generation int
+}
+
+// linkedPRsSnapshot keeps the PRs linked to an issue
+type linkedPRsSnapshot struct {
+	prs       []LinkedPR
+	fetchedAt time.Time
+}
+
+type inflightCall struct {
+	done    chan struct{}
+	tickets []GithubTicket
+	ticket  *GithubTicket
+	prs     []LinkedPR
+	err     error
+}
+
+var _ GithubClient = &CachedClient{}
+
+func NewCachedClient(client GithubClient, ttl time.Duration) *CachedClient {
+	return &CachedClient{
+		Client:    client,
+		TTL:       ttl,
+		snapshots: make(map[string]*repositorySnapshot),
+		linkedPRs: make(map[string]*linkedPRsSnapshot),
+		inflight:  make(map[string]*inflightCall),
+	}
+}
+
+// GetTickets returns the tickets of the repository snapshot, fetching them if the snapshot is expired
+func (c *CachedClient) GetTickets(ctx context.Context, repo string) ([]GithubTicket, error) {
+	key := repositoryKey(repo)
+
+	c.mu.Lock()
+	if tickets, ok := c.freshTickets(key); ok {
+		c.mu.Unlock()
+		return tickets, nil
+	}
+	generation := c.snapshot(key).generation
+	c.mu.Unlock()
+
+	call, err := c.coalesce(ctx, "list "+key, func(ctx context.Context, call *inflightCall) {
+		call.tickets, call.err = c.Client.GetTickets(ctx, repo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.snapshot(key)
+	if s.generation == generation {
+		s.tickets = call.tickets
+		s.fetchedAt = time.Now()
+	}
+	return copyTickets(call.tickets), nil
+}
+
+// GetTicket returns the ticket from the repository snapshot, if it is still valid, otherwise it fetches
+// only the requested ticket.
+func (c *CachedClient) GetTicket(ctx context.Context, repo string, number int64) (*GithubTicket, error) {
+	key := repositoryKey(repo)
+
+	c.mu.Lock()
+	if tickets, ok := c.freshTickets(key); ok {
+		c.mu.Unlock()
+		for _, t := range tickets {
+			if t.Number == number {
+				return &t, nil
+			}
+		}
+	} else {
+		c.mu.Unlock()
+	}
+
+	call, err := c.coalesce(ctx, fmt.Sprintf("get %s#%d", key, number), func(ctx context.Context, call *inflightCall) {
+		call.ticket, call.err = c.Client.GetTicket(ctx, repo, number)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if call.err != nil || call.ticket == nil {
+		return nil, call.err
+	}
+	ticket := *call.ticket
+	return &ticket, nil
+}
+
+func (c *CachedClient) CreateTicket(ctx context.Context, t GithubTicket) (*GithubTicket, error) {
+	defer c.invalidate(t.RepositoryURL)
+	return c.Client.CreateTicket(ctx, t)
+}
+
+func (c *CachedClient) UpdateTicket(ctx context.Context, current, desired GithubTicket) error {
+	defer c.invalidate(desired.RepositoryURL)
+	return c.Client.UpdateTicket(ctx, current, desired)
+}
+
+func (c *CachedClient) CommentTicket(ctx context.Context, t GithubTicket, body string) error {
+	return c.Client.CommentTicket(ctx, t, body)
+}
+
+func (c *CachedClient) LockTicket(ctx context.Context, t GithubTicket) error {
+	defer c.invalidate(t.RepositoryURL)
+	return c.Client.LockTicket(ctx, t)
+}
+
+// GetLinkedPRs returns the PRs linked to the issue, fetching them again only once they are older than TTL, since
+// every reconciliation of the issue needs them
+func (c *CachedClient) GetLinkedPRs(ctx context.Context, repo string, number int64) ([]LinkedPR, error) {
+	key := fmt.Sprintf("%s#%d", repositoryKey(repo), number)
+
+	c.mu.Lock()
+	if s, ok := c.linkedPRs[key]; ok && time.Since(s.fetchedAt) <= c.TTL {
+		c.mu.Unlock()
+		return append([]LinkedPR(nil), s.prs...), nil
+	}
+	c.mu.Unlock()
+
+	call, err := c.coalesce(ctx, "prs "+key, func(ctx context.Context, call *inflightCall) {
+		call.prs, call.err = c.Client.GetLinkedPRs(ctx, repo, number)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.linkedPRs[key] = &linkedPRsSnapshot{prs: call.prs, fetchedAt: time.Now()}
+	return append([]LinkedPR(nil), call.prs...), nil
+}
+
+func (c *CachedClient) invalidate(repo string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.snapshot(repositoryKey(repo))
+	s.tickets = nil
+	s.fetchedAt = time.Time{}
+	s.generation++
+}
+
+// freshTickets returns a copy of the snapshot tickets if the snapshot is not expired. Must be called with mu held.
+func (c *CachedClient) freshTickets(key string) ([]GithubTicket, bool) {
+	s, ok := c.snapshots[key]
+	if !ok || s.fetchedAt.IsZero() || time.Since(s.fetchedAt) > c.TTL {
+		return nil, false
+	}
+	return copyTickets(s.tickets), true
+}
+
+// snapshot returns the snapshot of the repository, creating an empty one if needed. Must be called with mu held.
+func (c *CachedClient) snapshot(key string) *repositorySnapshot {
+	s, ok := c.snapshots[key]
+	if !ok {
+		s = &repositorySnapshot{}
+		c.snapshots[key] = s
+	}
+	return s
+}
+
+// coalesce runs fn only once for all the concurrent callers using the same key, and returns its result to all of them.
+// fn runs on a context detached from the callers and bounded by Timeout, so that the result is shared even if the
+// caller that started it gives up. The callers waiting for the result stop waiting when their context is done.
+func (c *CachedClient) coalesce(ctx context.Context, key string, fn func(context.Context, *inflightCall)) (*inflightCall, error) {
+	c.mu.Lock()
+	call, ok := c.inflight[key]
+	if !ok {
+		call = &inflightCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		go func() {
+			fetchCtx, cancel := context.WithTimeout(context.Background(), c.timeout())
+			defer cancel()
+			fn(fetchCtx, call)
+
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *CachedClient) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DEFAULT_OPERATION_TIMEOUT
+	}
+	return c.Timeout
+}
+
+// repositoryKey identifies a repository by "host/owner/repository", so that both the web URL
+// (https://github.com/OWNER/REPOSITORY) and the API URL (https://api.github.com/repos/OWNER/REPOSITORY, or
+// https://HOST/api/v3/repos/OWNER/REPOSITORY on Github Enterprise Server) of a repository have the same key
+func repositoryKey(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return repo
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "api.")
+	return host + "/" + repositoryPath(repo)
+}
+
+// repositoryPath returns the "owner/repository" part of the web or API URL of a repository
+func repositoryPath(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil {
+		return repo
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 {
+		return strings.ToLower(u.Path)
+	}
+	return strings.ToLower(strings.Join(parts[len(parts)-2:], "/"))
+}
+
+func copyTickets(tickets []GithubTicket) []GithubTicket {
+	if tickets == nil {
+		return nil
+	}
+	return append([]GithubTicket{}, tickets...)
+}
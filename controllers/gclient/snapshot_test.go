@@ -0,0 +1,172 @@
+package gclient_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	"github.com/clobrano/githubissues-operator/controllers/gclient/mock"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github client repository snapshot", func() {
+	const repo = "https://github.com/owner/repo"
+
+	var (
+		mctrl   *gomock.Controller
+		mgc     *mock.MockGithubClient
+		tickets []gclient.GithubTicket
+	)
+
+	BeforeEach(func() {
+		mctrl = gomock.NewController(GinkgoT())
+		mgc = mock.NewMockGithubClient(mctrl)
+		tickets = []gclient.GithubTicket{
+			{Number: 1, Title: "issue 1 title", State: "open", RepositoryURL: "https://api.github.com/repos/owner/repo"},
+			{Number: 2, Title: "issue 2 title", State: "closed", RepositoryURL: "https://api.github.com/repos/owner/repo"},
+		}
+	})
+
+	AfterEach(func() {
+		mctrl.Finish()
+	})
+
+	It("coalesces concurrent requests for the same repository", func() {
+		release := make(chan struct{})
+		mgc.EXPECT().GetTickets(gomock.Any(), repo).DoAndReturn(func(context.Context, string) ([]gclient.GithubTicket, error) {
+			<-release
+			return tickets, nil
+		}).Times(1)
+
+		underTest := gclient.NewCachedClient(mgc, time.Minute)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				got, err := underTest.GetTickets(context.TODO(), repo)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(got).To(Equal(tickets))
+			}()
+		}
+		// give all the goroutines the time to wait for the same call
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+	})
+
+	It("shares the fetch even if the caller that started it gives up", func() {
+		release := make(chan struct{})
+		mgc.EXPECT().GetTickets(gomock.Any(), repo).DoAndReturn(func(ctx context.Context, _ string) ([]gclient.GithubTicket, error) {
+			select {
+			case <-release:
+				return tickets, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}).Times(1)
+
+		underTest := gclient.NewCachedClient(mgc, time.Minute)
+		ctx, cancel := context.WithCancel(context.TODO())
+		first := make(chan error)
+		go func() {
+			_, err := underTest.GetTickets(ctx, repo)
+			first <- err
+		}()
+		// give the first caller the time to start the fetch
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		Expect(<-first).To(MatchError(context.Canceled))
+
+		second := make(chan []gclient.GithubTicket)
+		go func() {
+			defer GinkgoRecover()
+			got, err := underTest.GetTickets(context.TODO(), repo)
+			Expect(err).ToNot(HaveOccurred())
+			second <- got
+		}()
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		Expect(<-second).To(Equal(tickets))
+	})
+
+	It("bounds the shared fetch with the timeout", func() {
+		mgc.EXPECT().GetTickets(gomock.Any(), repo).DoAndReturn(func(ctx context.Context, _ string) ([]gclient.GithubTicket, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+		underTest := gclient.NewCachedClient(mgc, time.Minute)
+		underTest.Timeout = 100 * time.Millisecond
+		_, err := underTest.GetTickets(context.TODO(), repo)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("serves tickets from the snapshot until it expires", func() {
+		mgc.EXPECT().GetTickets(gomock.Any(), repo).Return(tickets, nil).Times(1)
+
+		underTest := gclient.NewCachedClient(mgc, time.Minute)
+		_, err := underTest.GetTickets(context.TODO(), repo)
+		Expect(err).ToNot(HaveOccurred())
+
+		got, err := underTest.GetTickets(context.TODO(), repo)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(Equal(tickets))
+
+		// single tickets are served from the snapshot as well
+		ticket, err := underTest.GetTicket(context.TODO(), repo, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ticket).To(Equal(&tickets[1]))
+	})
+
+	It("serves the linked PRs until they expire", func() {
+		prs := []gclient.LinkedPR{{Number: 10, RepositoryURL: "https://api.github.com/repos/owner/repo", State: gclient.PR_STATE_OPEN}}
+		mgc.EXPECT().GetLinkedPRs(gomock.Any(), repo, int64(1)).Return(prs, nil).Times(1)
+		mgc.EXPECT().GetLinkedPRs(gomock.Any(), repo, int64(2)).Return(nil, nil).Times(1)
+
+		underTest := gclient.NewCachedClient(mgc, time.Minute)
+		for i := 0; i < 2; i++ {
+			got, err := underTest.GetLinkedPRs(context.TODO(), repo, 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got).To(Equal(prs))
+		}
+		got, err := underTest.GetLinkedPRs(context.TODO(), repo, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(BeEmpty())
+	})
+
+	It("fetches only the requested ticket if there is no valid snapshot", func() {
+		mgc.EXPECT().GetTicket(gomock.Any(), repo, int64(1)).Return(&tickets[0], nil).Times(2)
+
+		underTest := gclient.NewCachedClient(mgc, 0)
+		for i := 0; i < 2; i++ {
+			ticket, err := underTest.GetTicket(context.TODO(), repo, 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ticket).To(Equal(&tickets[0]))
+		}
+	})
+
+	It("invalidates the snapshot when a ticket is updated or created", func() {
+		mgc.EXPECT().GetTickets(gomock.Any(), repo).Return(tickets, nil).Times(3)
+		mgc.EXPECT().UpdateTicket(gomock.Any(), tickets[0], tickets[0]).Return(nil)
+		mgc.EXPECT().CreateTicket(gomock.Any(), gomock.Any()).Return(&tickets[1], nil)
+
+		underTest := gclient.NewCachedClient(mgc, time.Minute)
+		_, err := underTest.GetTickets(context.TODO(), repo)
+		Expect(err).ToNot(HaveOccurred())
+
+		// the ticket uses the API URL of the repository
+		Expect(underTest.UpdateTicket(context.TODO(), tickets[0], tickets[0])).To(Succeed())
+		_, err = underTest.GetTickets(context.TODO(), repo)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = underTest.CreateTicket(context.TODO(), gclient.GithubTicket{Title: "new issue", RepositoryURL: repo})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = underTest.GetTickets(context.TODO(), repo)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,167 @@
+package gclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DEFAULT_TOKEN_FILE_INTERVAL is how often FileToken checks the token file for changes
+const DEFAULT_TOKEN_FILE_INTERVAL time.Duration = 30 * time.Second
+
+// tokenExpirationHeader is the response header with the expiration of the token used for the request
+const tokenExpirationHeader = "github-authentication-token-expiration"
+
+var tokenlog = log.Log.WithName("github-token")
+
+var (
+	tokenInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "githubissues_github_token_info",
+		Help: "The fingerprint of the Github token loaded by the operator from each token file",
+	}, []string{"path", "fingerprint"})
+	tokenExpiration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "githubissues_github_token_expiration_timestamp_seconds",
+		Help: "The expiration of the Github token loaded by the operator from each token file, as reported by Github",
+	}, []string{"path", "fingerprint"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(tokenInfo, tokenExpiration)
+}
+
+// tokenExpirationObserver is implemented by the TokenSources that want to know the token expiration reported by Github
+type tokenExpirationObserver interface {
+	observeExpiration(repo, token string, expiration time.Time)
+}
+
+// FileToken reads the token from a file, e.g. a mounted Secret, and reloads it when the file changes, so that
+// the token can be rotated without restarting the operator.
+type FileToken struct {
+	Path string
+	// Interval is how often the file is checked for changes
+	Interval time.Duration
+
+	mu          sync.RWMutex
+	token       string
+	fingerprint string
+	expiration  time.Time
+}
+
+// NewFileToken returns a FileToken with the token already loaded from path
+func NewFileToken(path string, interval time.Duration) (*FileToken, error) {
+	f := &FileToken{Path: path, Interval: interval}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileToken) Token(_ context.Context, repo string) (string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.token == "" {
+		return "", fmt.Errorf("could not get github token for '%s': %w", repo, ErrUnauthorized)
+	}
+	return f.token, nil
+}
+
+// Fingerprint identifies the loaded token without disclosing it
+func (f *FileToken) Fingerprint() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.fingerprint
+}
+
+// Expiration returns the expiration of the loaded token reported by Github, or the zero time if unknown
+func (f *FileToken) Expiration() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.expiration
+}
+
+// Start checks the token file for changes until ctx is done. It implements manager.Runnable.
+func (f *FileToken) Start(ctx context.Context) error {
+	interval := f.Interval
+	if interval <= 0 {
+		interval = DEFAULT_TOKEN_FILE_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// keep the current token if the file can't be read, e.g. while the Secret is updated
+			if _, err := f.reload(); err != nil {
+				tokenlog.Error(err, "could not reload Github token", "path", f.Path)
+			}
+		}
+	}
+}
+
+// reload reads the token file, and swaps the token if it changed
+func (f *FileToken) reload() (bool, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return false, fmt.Errorf("could not read Github token file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return false, fmt.Errorf("Github token file %s is empty", f.Path)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if token == f.token {
+		return false, nil
+	}
+	// the series of the other token files are kept
+	if f.fingerprint != "" {
+		tokenInfo.DeleteLabelValues(f.Path, f.fingerprint)
+		tokenExpiration.DeleteLabelValues(f.Path, f.fingerprint)
+	}
+	f.token = token
+	f.fingerprint = tokenFingerprint(token)
+	f.expiration = time.Time{}
+
+	tokenInfo.WithLabelValues(f.Path, f.fingerprint).Set(1)
+	tokenlog.Info("loaded Github token", "fingerprint", f.fingerprint, "path", f.Path)
+	return true, nil
+}
+
+func (f *FileToken) observeExpiration(_, token string, expiration time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if token != f.token || expiration.Equal(f.expiration) {
+		return
+	}
+	f.expiration = expiration
+	tokenExpiration.WithLabelValues(f.Path, f.fingerprint).Set(float64(expiration.Unix()))
+	tokenlog.Info("Github token expiration", "fingerprint", f.fingerprint, "path", f.Path, "expiration", expiration)
+}
+
+// tokenFingerprint returns the first bytes of the token hash, enough to tell tokens apart in logs and metrics
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseTokenExpiration parses the github-authentication-token-expiration header, e.g. "2023-03-08 20:07:54 UTC"
+func parseTokenExpiration(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse token expiration %q", value)
+}
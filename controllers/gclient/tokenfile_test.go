@@ -0,0 +1,147 @@
+package gclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var _ = Describe("Github token file", func() {
+	var (
+		ts           *httptest.Server
+		path         string
+		authorizedBy []string
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "token")
+		Expect(os.WriteFile(path, []byte("first token\n"), 0600)).To(Succeed())
+		authorizedBy = nil
+
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			authorizedBy = append(authorizedBy, auth)
+			if auth == "first token" {
+				w.Header().Set("github-authentication-token-expiration", "2030-03-08 20:07:54 UTC")
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`[]`))
+		}))
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("fails when the file can't be read", func() {
+		_, err := gclient.NewFileToken(filepath.Join(GinkgoT().TempDir(), "missing"), time.Second)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reloads the token when the file changes", func() {
+		tokenFile, err := gclient.NewFileToken(path, 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		firstFingerprint := tokenFile.Fingerprint()
+		Expect(firstFingerprint).ToNot(BeEmpty())
+		Expect(firstFingerprint).ToNot(ContainSubstring("first token"))
+		Expect(tokenFile.Expiration().IsZero()).To(BeTrue())
+
+		client := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, Credentials: tokenFile}
+		_, err = client.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokenFile.Expiration()).To(Equal(time.Date(2030, 3, 8, 20, 7, 54, 0, time.UTC)))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go tokenFile.Start(ctx)
+
+		Expect(os.WriteFile(path, []byte("second token\n"), 0600)).To(Succeed())
+		Eventually(tokenFile.Fingerprint).ShouldNot(Equal(firstFingerprint))
+		// the expiration of the previous token no longer applies
+		Expect(tokenFile.Expiration().IsZero()).To(BeTrue())
+
+		_, err = client.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authorizedBy).To(Equal([]string{"first token", "second token"}))
+	})
+
+	It("reports the expiration of the token of each host", func() {
+		otherPath := filepath.Join(GinkgoT().TempDir(), "other-token")
+		Expect(os.WriteFile(otherPath, []byte("other token\n"), 0600)).To(Succeed())
+		tokenFile, err := gclient.NewFileToken(path, 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		otherTokenFile, err := gclient.NewFileToken(otherPath, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		credentials := &gclient.HostTokens{
+			Hosts:  gclient.Hosts{"github.example.com": ts.URL},
+			Tokens: map[string]gclient.TokenSource{gclient.GITHUB_HOST: tokenFile, "github.example.com": otherTokenFile},
+		}
+		client := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, Credentials: credentials}
+		_, err = client.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokenFile.Expiration()).To(Equal(time.Date(2030, 3, 8, 20, 7, 54, 0, time.UTC)))
+		Expect(otherTokenFile.Expiration().IsZero()).To(BeTrue())
+		Expect(tokenSeries("githubissues_github_token_expiration_timestamp_seconds")).To(
+			HaveKeyWithValue(path+"/"+tokenFile.Fingerprint(), float64(1899230874)))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go tokenFile.Start(ctx)
+
+		// the series of the previous token are replaced, the ones of the other host are kept
+		firstFingerprint := tokenFile.Fingerprint()
+		Expect(os.WriteFile(path, []byte("second token\n"), 0600)).To(Succeed())
+		Eventually(tokenFile.Fingerprint).ShouldNot(Equal(firstFingerprint))
+		Expect(tokenSeries("githubissues_github_token_info")).To(And(
+			HaveKey(path+"/"+tokenFile.Fingerprint()),
+			HaveKey(otherPath+"/"+otherTokenFile.Fingerprint()),
+			Not(HaveKey(path+"/"+firstFingerprint)),
+		))
+		Expect(tokenSeries("githubissues_github_token_expiration_timestamp_seconds")).ToNot(
+			HaveKey(path + "/" + firstFingerprint))
+	})
+
+	It("keeps the current token while the file is unreadable", func() {
+		tokenFile, err := gclient.NewFileToken(path, 10*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go tokenFile.Start(ctx)
+
+		Expect(os.WriteFile(path, []byte(""), 0600)).To(Succeed())
+		Consistently(func() (string, error) {
+			return tokenFile.Token(context.TODO(), "owner/repo")
+		}, 50*time.Millisecond).Should(Equal("first token"))
+	})
+})
+
+// tokenSeries returns the values of the series of the token metric, by "path/fingerprint"
+func tokenSeries(name string) map[string]float64 {
+	families, err := metrics.Registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	series := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			series[labels["path"]+"/"+labels["fingerprint"]] = m.GetGauge().GetValue()
+		}
+	}
+	return series
+}
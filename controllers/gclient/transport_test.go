@@ -0,0 +1,84 @@
+package gclient_test
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Github transport", func() {
+	BeforeEach(func() {
+		os.Setenv("GITHUB_TOKEN", "fake github token")
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("GITHUB_TOKEN")
+	})
+
+	It("trusts the certificate authorities of the CA bundle", func() {
+		ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`[]`))
+		}))
+		defer ts.Close()
+
+		caBundle := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+		Expect(os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: ts.Certificate().Raw,
+		}), 0600)).To(Succeed())
+
+		transport, err := gclient.NewTransport(gclient.TransportConfig{})
+		Expect(err).ToNot(HaveOccurred())
+		underTest := gclient.GClient{BaseURL: ts.URL, CacheSize: -1, Transport: transport}
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).To(HaveOccurred())
+
+		transport, err = gclient.NewTransport(gclient.TransportConfig{CABundle: caBundle})
+		Expect(err).ToNot(HaveOccurred())
+		underTest = gclient.GClient{BaseURL: ts.URL, CacheSize: -1, Transport: transport}
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("sends the requests through the proxy", func() {
+		var proxied []string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = append(proxied, r.URL.String()+" "+r.Header.Get("Proxy-Authorization"))
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`[]`))
+		}))
+		defer proxy.Close()
+
+		transport, err := gclient.NewTransport(gclient.TransportConfig{
+			ProxyURL: "http://user:password@" + proxy.Listener.Addr().String(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		underTest := gclient.GClient{BaseURL: "http://github.example.com/repos", CacheSize: -1, Transport: transport}
+		_, err = underTest.GetTickets(context.TODO(), "https://github.com/owner/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(proxied).To(Equal([]string{
+			"http://github.example.com/repos/owner/repo/issues?state=all&sort=updated&direction=asc&per_page=100 Basic dXNlcjpwYXNzd29yZA==",
+		}))
+	})
+
+	It("refuses invalid configurations", func() {
+		_, err := gclient.NewTransport(gclient.TransportConfig{ProxyURL: "not a url"})
+		Expect(err).To(HaveOccurred())
+
+		caBundle := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+		Expect(os.WriteFile(caBundle, []byte("not a certificate"), 0600)).To(Succeed())
+		_, err = gclient.NewTransport(gclient.TransportConfig{CABundle: caBundle})
+		Expect(err).To(HaveOccurred())
+
+		_, err = gclient.NewTransport(gclient.TransportConfig{ClientCert: "missing.crt", ClientKey: "missing.key"})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -18,18 +18,25 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	trainingv1alpha1 "github.com/clobrano/githubissues-operator/api/v1alpha1"
 	"github.com/clobrano/githubissues-operator/controllers/gclient"
@@ -37,16 +44,34 @@ import (
 
 const GIFinalizer = "training.redhat.com/gifinalizer"
 
+// ForceDeletionAnnotation, set to "true", removes the finalizer of a deleted GithubIssue without applying its
+// DeletionPolicy, e.g. when Github cannot be reached
+const ForceDeletionAnnotation = "training.redhat.com/force-deletion"
+
+// terminalErrorRequeueAfter is the delay before retrying a request that failed because of an error that needs a
+// user intervention (e.g. wrong token or repository)
+const terminalErrorRequeueAfter = 10 * time.Minute
+
 // GithubIssueReconciler reconciles a GithubIssue object
 type GithubIssueReconciler struct {
 	client.Client
 	Scheme     *runtime.Scheme
 	RepoClient gclient.GithubClient
+	// NewRepoClient builds the Github client for the GithubIssues with their own credentials
+	NewRepoClient func(gclient.TokenSource) gclient.GithubClient
+
+	// DeletionTimeout is how long the DeletionPolicy of a deleted GithubIssue is retried before removing its
+	// finalizer anyway. Zero means no timeout
+	DeletionTimeout time.Duration
+
+	repoClientsMu sync.Mutex
+	repoClients   map[types.NamespacedName]credentialedClient
 }
 
 //+kubebuilder:rbac:groups=training.redhat.com,resources=githubissues,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=training.redhat.com,resources=githubissues/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=training.redhat.com,resources=githubissues/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -70,7 +95,8 @@ func (r *GithubIssueReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 
-	if !controllerutil.ContainsFinalizer(gi, GIFinalizer) {
+	isGithubIssueMarkedToBeDeleted := !gi.DeletionTimestamp.IsZero()
+	if !isGithubIssueMarkedToBeDeleted && !controllerutil.ContainsFinalizer(gi, GIFinalizer) {
 		controllerutil.AddFinalizer(gi, GIFinalizer)
 		err = r.Update(ctx, gi)
 		if err != nil {
@@ -78,10 +104,10 @@ func (r *GithubIssueReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
-	isGithubIssueMarkedToBeDeleted := !gi.DeletionTimestamp.IsZero()
 	giOrig := gi.DeepCopy()
 	defer func() {
-		if isGithubIssueMarkedToBeDeleted {
+		if !controllerutil.ContainsFinalizer(gi, GIFinalizer) {
+			// the resource is gone
 			return
 		}
 		mergeFrom := client.MergeFrom(giOrig)
@@ -97,26 +123,31 @@ func (r *GithubIssueReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}()
 
-	target, err := r.getMatchingTarget(gi.Status.TrackedIssueId, gi.Spec.Repo, gi.Spec.Title)
+	if isGithubIssueMarkedToBeDeleted {
+		return r.reconcileDeletion(ctx, gi)
+	}
+
+	repoClient, err := r.getRepoClient(ctx, gi)
+	if err != nil {
+		l.Error(err, "could not get Github credentials", "Secret", gi.Spec.CredentialsSecretRef)
+		return r.handleCredentialsError(gi, err)
+	}
+
+	target, err := r.getTarget(ctx, repoClient, gi)
 	if err != nil {
 		l.Error(err, "could not get matching ticket", "Repo URL", gi.Spec.Repo)
-		return ctrl.Result{}, err
+		return r.handleGithubError(gi, err)
 	}
 
-	if isGithubIssueMarkedToBeDeleted {
-		if target != nil && target.State == "open" {
-			target.State = "closed"
-			err = r.RepoClient.UpdateTicket(*target)
-			if err != nil {
-				l.Error(err, "could not close ticket", "Ticket", target)
-			}
-		}
-		controllerutil.RemoveFinalizer(gi, GIFinalizer)
-		err = r.Update(ctx, gi)
-		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("could not remove finalizer: %v", err)
-		}
-		return ctrl.Result{}, nil
+	if target == nil && gi.Spec.IssueNumber != 0 {
+		// the issue to adopt is not created, it might have been deleted or transferred
+		meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
+			Type:    "Synchronized",
+			Status:  metav1.ConditionFalse,
+			Reason:  "IssueNotFound",
+			Message: fmt.Sprintf("GithubIssue operator could not find the issue %d to adopt", gi.Spec.IssueNumber),
+		})
+		return ctrl.Result{RequeueAfter: terminalErrorRequeueAfter}, nil
 	}
 
 	if target == nil {
@@ -126,22 +157,21 @@ func (r *GithubIssueReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			Body:          gi.Spec.Description,
 			State:         "open",
 			RepositoryURL: gi.Spec.Repo,
+			Labels:        gi.Spec.Labels,
+			Assignees:     gi.Spec.Assignees,
+			Milestone:     gi.Spec.Milestone,
 		}
 
-		err = r.RepoClient.CreateTicket(newTicket)
-		if err != nil {
-			return ctrl.Result{}, err
-		}
-
-		// immediately get the newly created ticket for linkage with Status.Number
-		target, err = r.getMatchingTarget(gi.Status.TrackedIssueId, gi.Spec.Repo, gi.Spec.Title)
+		// the created ticket is used for linkage with Status.TrackedIssueId
+		target, err = repoClient.CreateTicket(ctx, newTicket)
 		if err != nil {
-			l.Error(err, "could not get matching ticket", "Repo URL", gi.Spec.Repo)
-			return ctrl.Result{}, err
+			return r.handleGithubError(gi, err)
 		}
+		l.Info("Reconcile", "Created ticket", target.Number)
 	}
 
-	if gi.Status.TrackedIssueId == 0 {
+	if gi.Status.TrackedIssueId != target.Number {
+		// the issue is tracked for the first time, or adopted
 		gi.Status.TrackedIssueId = target.Number
 		err := r.Client.Status().Update(ctx, gi)
 		if err != nil {
@@ -150,15 +180,41 @@ func (r *GithubIssueReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
-	if target.State == "open" {
-		if !isGithubIssueMarkedToBeDeleted {
-			meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
-				Type:    "IsOpen",
-				Status:  metav1.ConditionTrue,
-				Reason:  "IssueIsOpen",
-				Message: "GithubIssue operator detected that the issue is open",
-			})
+	// the ticket is updated first, so that the conditions report its new state
+	desired := desiredState(gi, desiredMetadata(gi, *target))
+	unassignable := unassignableAssignees(gi)
+	if target.Title != gi.Spec.Title || target.Body != gi.Spec.Description ||
+		metadataChanged(*target, desired) || stateChanged(*target, desired) {
+		desired.Title = gi.Spec.Title
+		desired.Body = gi.Spec.Description
+		err = repoClient.UpdateTicket(ctx, *target, desired)
+		var dropped *gclient.AssigneesDroppedError
+		if errors.As(err, &dropped) {
+			// Github ignores the assignees without access, they would be requested again at every resync
+			l.Info("Reconcile", "Dropped assignees", dropped.Assignees)
+			var assignees []string
+			for _, a := range desired.Assignees {
+				if !containsFold(dropped.Assignees, a) {
+					assignees = append(assignees, a)
+				}
+			}
+			desired.Assignees = assignees
+			unassignable = append(unassignable, dropped.Assignees...)
+		} else if err != nil {
+			return r.handleGithubError(gi, fmt.Errorf("could not update ticket: %w", err))
 		}
+		l.Info("Reconcile", "Updated ticket", target.Number)
+		target = &desired
+	}
+	setMetadataStatus(gi, *target, unassignable)
+
+	if target.State == "open" {
+		meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
+			Type:    "IsOpen",
+			Status:  metav1.ConditionTrue,
+			Reason:  "IssueIsOpen",
+			Message: "GithubIssue operator detected that the issue is open",
+		})
 	} else {
 		meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
 			Type:    "IsOpen",
@@ -167,7 +223,14 @@ func (r *GithubIssueReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			Message: "GithubIssue operator detected that the issue is closed",
 		})
 	}
-	if r.RepoClient.IssueHasPR(*target) {
+
+	linkedPRs, err := repoClient.GetLinkedPRs(ctx, gi.Spec.Repo, target.Number)
+	if err != nil {
+		l.Error(err, "could not get linked PRs", "Ticket", target.Number)
+		return r.handleGithubError(gi, err)
+	}
+	gi.Status.LinkedPullRequests = toLinkedPullRequests(linkedPRs)
+	if len(linkedPRs) > 0 {
 		meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
 			Type:    "HasPr",
 			Status:  metav1.ConditionTrue,
@@ -183,28 +246,198 @@ func (r *GithubIssueReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		})
 	}
 
-	if target.Title != gi.Spec.Title || target.Body != gi.Spec.Description {
-		target.Title = gi.Spec.Title
-		target.Body = gi.Spec.Description
-		err = r.RepoClient.UpdateTicket(*target)
-		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("could not update ticket: %v", err)
+	meta.SetStatusCondition(&gi.Status.Conditions, checksCondition(linkedPRs))
+	setResolvedCondition(gi, target, linkedPRs)
+
+	meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
+		Type:    "Synchronized",
+		Status:  metav1.ConditionTrue,
+		Reason:  "Synchronized",
+		Message: "GithubIssue operator synchronized the issue with Github",
+	})
+	return ctrl.Result{RequeueAfter: time.Minute}, nil
+}
+
+// setResolvedCondition reports if the issue was resolved: closed as completed, or closed by a merged linked PR,
+// whose merge commit is recorded in the status. An issue closed as not planned is not resolved.
+func setResolvedCondition(gi *trainingv1alpha1.GithubIssue, ticket *gclient.GithubTicket, prs []gclient.LinkedPR) {
+	var merged *gclient.LinkedPR
+	for i := range prs {
+		if prs[i].State == gclient.PR_STATE_MERGED && (merged == nil || prs[i].MergedAt.After(merged.MergedAt)) {
+			merged = &prs[i]
 		}
-		l.Info("Reconcile", "Updated ticket", target.Number)
 	}
 
-	return ctrl.Result{RequeueAfter: time.Minute}, nil
+	gi.Status.MergeCommitSHA = ""
+	gi.Status.MergedAt = nil
+	condition := metav1.Condition{Type: "Resolved"}
+	switch {
+	case ticket.State == "open":
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "IssueIsOpen"
+		condition.Message = "GithubIssue operator detected that the issue is still open"
+	case ticket.StateReason == "not_planned":
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NotPlanned"
+		condition.Message = "GithubIssue operator detected that the issue was closed as not planned"
+	case merged != nil:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "PRMerged"
+		condition.Message = fmt.Sprintf("GithubIssue operator detected that the issue was resolved by the merged PR %s", merged.HTMLURL)
+		gi.Status.MergeCommitSHA = merged.MergeCommitSHA
+		if !merged.MergedAt.IsZero() {
+			mergedAt := metav1.NewTime(merged.MergedAt)
+			gi.Status.MergedAt = &mergedAt
+		}
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Completed"
+		condition.Message = "GithubIssue operator detected that the issue was closed as completed"
+	}
+	meta.SetStatusCondition(&gi.Status.Conditions, condition)
+}
+
+// checksCondition reports if the CI checks of the open linked PRs are passing. The PRs without checks are ignored.
+func checksCondition(prs []gclient.LinkedPR) metav1.Condition {
+	var open, failing, pending, passing int
+	for _, pr := range prs {
+		if pr.State != gclient.PR_STATE_OPEN {
+			continue
+		}
+		open++
+		switch pr.Checks {
+		case gclient.CHECKS_FAILURE:
+			failing++
+		case gclient.CHECKS_PENDING:
+			pending++
+		case gclient.CHECKS_SUCCESS:
+			passing++
+		}
+	}
+
+	condition := metav1.Condition{Type: "ChecksPassing"}
+	switch {
+	case open == 0:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "NoOpenPR"
+		condition.Message = "GithubIssue operator detected no open PR linked to this issue"
+	case failing > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ChecksFailing"
+		condition.Message = fmt.Sprintf("GithubIssue operator detected failing checks on %d of %d open linked PRs", failing, open)
+	case pending > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ChecksPending"
+		condition.Message = fmt.Sprintf("GithubIssue operator detected pending checks on %d of %d open linked PRs", pending, open)
+	case passing > 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "ChecksPassing"
+		condition.Message = "GithubIssue operator detected passing checks on the open linked PRs"
+	default:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "NoChecks"
+		condition.Message = "GithubIssue operator detected no checks on the open linked PRs"
+	}
+	return condition
+}
+
+// toLinkedPullRequests converts the linked PRs for the GithubIssue status
+func toLinkedPullRequests(prs []gclient.LinkedPR) []trainingv1alpha1.LinkedPullRequest {
+	var linked []trainingv1alpha1.LinkedPullRequest
+	for _, pr := range prs {
+		linked = append(linked, trainingv1alpha1.LinkedPullRequest{
+			Number:         pr.Number,
+			URL:            pr.HTMLURL,
+			State:          pr.State,
+			Draft:          pr.Draft,
+			Author:         pr.Author,
+			ReviewDecision: pr.ReviewDecision,
+			HeadSHA:        pr.HeadSHA,
+			Checks:         pr.Checks,
+		})
+	}
+	return linked
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *GithubIssueReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&trainingv1alpha1.GithubIssue{}).
+		// only the metadata of the Secrets is cached, the Secrets are read from the API server, see main.go
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.findGithubIssuesForSecret),
+			builder.OnlyMetadata).
 		Complete(r)
 }
 
-func (r *GithubIssueReconciler) getMatchingTarget(issueId int64, url, title string) (*gclient.GithubTicket, error) {
-	tickets, err := r.RepoClient.GetTickets(url)
+// handleGithubError reports a failed Github request in the Synchronized condition, and decides how to retry.
+// Rate limited requests are requeued when the rate limit is reset, transient errors are retried with backoff,
+// while errors that need a user intervention (e.g. wrong token or repository) are retried only after a long delay.
+func (r *GithubIssueReconciler) handleGithubError(gi *trainingv1alpha1.GithubIssue, err error) (ctrl.Result, error) {
+	condition := metav1.Condition{
+		Type:    "Synchronized",
+		Status:  metav1.ConditionFalse,
+		Message: err.Error(),
+	}
+
+	var rateLimited *gclient.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		condition.Reason = "RateLimited"
+		meta.SetStatusCondition(&gi.Status.Conditions, condition)
+		requeueAfter := time.Until(rateLimited.Reset)
+		if requeueAfter < time.Second {
+			requeueAfter = time.Second
+		}
+		return ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+
+	var terminal bool
+	condition.Reason, terminal = githubErrorReason(err)
+	meta.SetStatusCondition(&gi.Status.Conditions, condition)
+
+	if terminal {
+		return ctrl.Result{RequeueAfter: terminalErrorRequeueAfter}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// githubErrorReason returns the condition reason of a failed Github request, and whether the error needs a user
+// intervention
+func githubErrorReason(err error) (string, bool) {
+	switch {
+	case errors.Is(err, gclient.ErrUnauthorized):
+		return "AuthenticationFailed", true
+	case errors.Is(err, gclient.ErrNotFound):
+		return "RepositoryNotFound", true
+	case errors.Is(err, gclient.ErrForbidden):
+		return "Forbidden", true
+	case errors.Is(err, gclient.ErrValidation):
+		return "ValidationFailed", true
+	case errors.Is(err, gclient.ErrTransient):
+		return "Transient", false
+	default:
+		return "RequestFailed", false
+	}
+}
+
+// getTarget returns the issue tracked by the GithubIssue: the adopted one if Spec.IssueNumber is set, otherwise
+// the one matching Status.TrackedIssueId or the title. It returns nil if there is no such issue.
+func (r *GithubIssueReconciler) getTarget(ctx context.Context, repoClient gclient.GithubClient, gi *trainingv1alpha1.GithubIssue) (*gclient.GithubTicket, error) {
+	if gi.Spec.IssueNumber != 0 {
+		return repoClient.GetTicket(ctx, gi.Spec.Repo, gi.Spec.IssueNumber)
+	}
+	return r.getMatchingTarget(ctx, repoClient, gi.Status.TrackedIssueId, gi.Spec.Repo, gi.Spec.Title)
+}
+
+func (r *GithubIssueReconciler) getMatchingTarget(ctx context.Context, repoClient gclient.GithubClient, issueId int64, url, title string) (*gclient.GithubTicket, error) {
+	if issueId != 0 {
+		// the issue is already tracked, fetch only that one
+		target, err := repoClient.GetTicket(ctx, url, issueId)
+		if err != nil || target != nil {
+			return target, err
+		}
+	}
+
+	tickets, err := repoClient.GetTickets(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/clobrano/githubissues-operator/api/v1alpha1"
 	"github.com/clobrano/githubissues-operator/controllers/gclient"
@@ -10,6 +11,8 @@ import (
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -88,27 +91,180 @@ var _ = Describe("GithubissueController", func() {
 			It("should create it", func() {
 				want := newExpectedGithubTicket()
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{
 					{Title: "Title different than expected"},
 				}, nil)
-				mgc.EXPECT().CreateTicket(want).Return(nil)
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{want}, nil)
-				mgc.EXPECT().IssueHasPR(want).Return(false)
+				created := want
+				created.Number = 42
+				created.HTMLURL = "https://github.com/clobrano/githubissues-operator/issues/42"
+				mgc.EXPECT().CreateTicket(gomock.Any(), want).Return(&created, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, created.Number).Return(nil, nil)
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.TrackedIssueId).To(Equal(created.Number))
 			})
 
 			It("should return with error if it cannot create it", func() {
 				want := newExpectedGithubTicket()
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{}, nil)
-				mgc.EXPECT().CreateTicket(want).Return(fmt.Errorf("could not send Github API request"))
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{}, nil)
+				mgc.EXPECT().CreateTicket(gomock.Any(), want).Return(nil, fmt.Errorf("could not send Github API request"))
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		When("the Github rate limit is exhausted", func() {
+			It("should requeue at the rate limit reset", func() {
+				reset := time.Now().Add(10 * time.Minute)
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return(nil, &gclient.RateLimitedError{Reset: reset})
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				result, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(BeNumerically("~", 10*time.Minute, time.Second))
+			})
+		})
+
+		When("Github refuses the request", func() {
+			It("should report a terminal failure without returning an error", func() {
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return(nil,
+					&gclient.RequestError{StatusCode: 401, Kind: gclient.ErrUnauthorized})
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				result, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(Equal(terminalErrorRequeueAfter))
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "Synchronized"),
+						HaveField("Status", metav1.ConditionFalse),
+						HaveField("Reason", "AuthenticationFailed"),
+					)))
+			})
+
+			It("should retry transient failures with backoff", func() {
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return(nil,
+					&gclient.RequestError{StatusCode: 502, Kind: gclient.ErrTransient})
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).To(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "Synchronized"),
+						HaveField("Status", metav1.ConditionFalse),
+						HaveField("Reason", "Transient"),
+					)))
+			})
+		})
+
+		When("the issue uses its own credentials", func() {
+			var secretClient *mock.MockGithubClient
+
+			BeforeEach(func() {
+				secretClient = mock.NewMockGithubClient(mctrl)
+				underTest.Spec.CredentialsSecretRef = &corev1.LocalObjectReference{Name: "team-a-token"}
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+			})
+
+			It("should use a client with the Secret token", func() {
+				secret := &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "team-a-token", Namespace: "default"},
+					Data:       map[string][]byte{CredentialsSecretTokenKey: []byte("team A token\n")},
+				}
+				Expect(myClient.Create(context.Background(), secret)).To(Succeed())
+
+				currentTicket := newExpectedGithubTicket()
+				secretClient.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil).Times(2)
+				secretClient.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number).Times(2)
+
+				var tokens []gclient.TokenSource
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc,
+					NewRepoClient: func(ts gclient.TokenSource) gclient.GithubClient {
+						tokens = append(tokens, ts)
+						return secretClient
+					}}
+				for i := 0; i < 2; i++ {
+					_, err := r.Reconcile(context.TODO(), req)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				// the client is built only once for the same token
+				Expect(tokens).To(Equal([]gclient.TokenSource{gclient.StaticToken("team A token")}))
+			})
+
+			It("should report a missing Secret", func() {
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "Synchronized"),
+						HaveField("Status", metav1.ConditionFalse),
+						HaveField("Reason", "CredentialsSecretNotFound"),
+					)))
+			})
+
+			It("should report a Secret without token", func() {
+				secret := &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "team-a-token", Namespace: "default"},
+					Data:       map[string][]byte{"password": []byte("team A token")},
+				}
+				Expect(myClient.Create(context.Background(), secret)).To(Succeed())
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "Synchronized"),
+						HaveField("Status", metav1.ConditionFalse),
+						HaveField("Reason", "CredentialsSecretInvalid"),
+					)))
+			})
+
+			It("should report a Secret with a blank token", func() {
+				secret := &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "team-a-token", Namespace: "default"},
+					Data:       map[string][]byte{CredentialsSecretTokenKey: []byte(" \n")},
+				}
+				Expect(myClient.Create(context.Background(), secret)).To(Succeed())
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "Synchronized"),
+						HaveField("Status", metav1.ConditionFalse),
+						HaveField("Reason", "CredentialsSecretInvalid"),
+					)))
+			})
+
+			It("should be reconciled when the Secret changes", func() {
+				other := newGithubIssue("another title", "another description")
+				other.Name = "other"
+				Expect(myClient.Create(context.Background(), other)).To(Succeed())
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "team-a-token", Namespace: "default"}}
+				Expect(r.findGithubIssuesForSecret(secret)).To(ConsistOf(req))
 			})
 		})
 
@@ -117,14 +273,14 @@ var _ = Describe("GithubissueController", func() {
 				currentTicketHasWrongDescription := newExpectedGithubTicket()
 				currentTicketHasWrongDescription.Number = 123
 				currentTicketHasWrongDescription.Body = "a different issue description"
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicketHasWrongDescription}, nil)
-				mgc.EXPECT().IssueHasPR(currentTicketHasWrongDescription)
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicketHasWrongDescription}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicketHasWrongDescription.Number)
 
 				want := newExpectedGithubTicket()
 				want.Number = 123
-				mgc.EXPECT().UpdateTicket(want).Return(nil)
+				mgc.EXPECT().UpdateTicket(gomock.Any(), currentTicketHasWrongDescription, want).Return(nil)
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
 			})
@@ -134,27 +290,101 @@ var _ = Describe("GithubissueController", func() {
 				currentTicket.Number = 1
 				currentTicket.Body = "a different issue description"
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
-				mgc.EXPECT().IssueHasPR(currentTicket)
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
 
 				want := newExpectedGithubTicket()
 				want.Number = 1
-				mgc.EXPECT().UpdateTicket(want).Return(fmt.Errorf("could not send github API request"))
+				mgc.EXPECT().UpdateTicket(gomock.Any(), currentTicket, want).Return(fmt.Errorf("could not send github API request"))
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).To(HaveOccurred())
 			})
 		})
 
+		When("the labels, assignees or milestone change", func() {
+			It("should update only the ones managed by the operator", func() {
+				underTest.Spec.Labels = []string{"feature"}
+				underTest.Spec.Assignees = []string{"alice"}
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+				underTest.Status.ManagedLabels = []string{"bug"}
+				underTest.Status.ManagedMilestone = "v1.0"
+				Expect(myClient.Status().Update(context.Background(), underTest)).To(Succeed())
+
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.Number = 123
+				currentTicket.Labels = []string{"Bug", "external"}
+				currentTicket.Assignees = []string{"carol"}
+				currentTicket.Milestone = "v1.0"
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
+
+				want := currentTicket
+				want.Labels = []string{"external", "feature"}
+				want.Assignees = []string{"carol", "alice"}
+				want.Milestone = ""
+				mgc.EXPECT().UpdateTicket(gomock.Any(), currentTicket, want).Return(nil)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Labels).To(Equal(want.Labels))
+				Expect(underTest.Status.Assignees).To(Equal(want.Assignees))
+				Expect(underTest.Status.Milestone).To(BeEmpty())
+				Expect(underTest.Status.ManagedLabels).To(Equal([]string{"feature"}))
+				Expect(underTest.Status.ManagedAssignees).To(Equal([]string{"alice"}))
+				Expect(underTest.Status.ManagedMilestone).To(BeEmpty())
+			})
+
+			It("should report the assignees that Github did not assign, without requesting them again", func() {
+				underTest.Spec.Assignees = []string{"alice", "mallory"}
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.Number = 123
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
+				want := currentTicket
+				want.Assignees = []string{"alice", "mallory"}
+				mgc.EXPECT().UpdateTicket(gomock.Any(), currentTicket, want).
+					Return(&gclient.AssigneesDroppedError{Assignees: []string{"mallory"}})
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Assignees).To(Equal([]string{"alice"}))
+				Expect(underTest.Status.UnassignableAssignees).To(Equal([]string{"mallory"}))
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "AssigneesDropped"),
+						HaveField("Status", metav1.ConditionTrue),
+						HaveField("Reason", "AssigneesCannotBeAssigned"),
+					)))
+
+				// the ticket is not updated at the next resync
+				assigned := currentTicket
+				assigned.Assignees = []string{"alice"}
+				mgc.EXPECT().GetTicket(gomock.Any(), underTest.Spec.Repo, assigned.Number).Return(&assigned, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, assigned.Number)
+				_, err = r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.UnassignableAssignees).To(Equal([]string{"mallory"}))
+			})
+		})
+
 		When("the issue is linked and the title change in Github", func() {
 			It("should not create a new ticket", func() {
 				currentTicketIsUpToDate := newExpectedGithubTicket()
 				currentTicketIsUpToDate.Number = 123
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicketIsUpToDate}, nil)
-				mgc.EXPECT().IssueHasPR(currentTicketIsUpToDate)
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicketIsUpToDate}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicketIsUpToDate.Number)
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 
 				_, err := r.Reconcile(context.TODO(), req)
 
@@ -165,25 +395,57 @@ var _ = Describe("GithubissueController", func() {
 				currentTicketWasChanged := currentTicketIsUpToDate
 				currentTicketWasChanged.Title = "Title has changed"
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicketWasChanged}, nil)
-				mgc.EXPECT().IssueHasPR(currentTicketWasChanged)
+				returnedTicket := currentTicketWasChanged
+				mgc.EXPECT().GetTicket(gomock.Any(), underTest.Spec.Repo, currentTicketIsUpToDate.Number).Return(&returnedTicket, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicketWasChanged.Number)
 				// Expecting the ticket's title to be reverted back to Spec
 				currentTicketWasChanged.Title = expectedIssueTitle
-				mgc.EXPECT().UpdateTicket(currentTicketWasChanged).Return(nil)
-				r = &GithubIssueReconciler{myClient, sch, mgc}
+				mgc.EXPECT().UpdateTicket(gomock.Any(), returnedTicket, currentTicketWasChanged).Return(nil)
+				r = &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err = r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
 			})
 		})
 
+		When("the issue is already tracked", func() {
+			It("should get only the tracked ticket", func() {
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.Number = 123
+				underTest.Status.TrackedIssueId = currentTicket.Number
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+
+				mgc.EXPECT().GetTicket(gomock.Any(), underTest.Spec.Repo, currentTicket.Number).Return(&currentTicket, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should look for the ticket by title if the tracked one is not found", func() {
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.Number = 123
+				underTest.Status.TrackedIssueId = 100
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+
+				mgc.EXPECT().GetTicket(gomock.Any(), underTest.Spec.Repo, int64(100)).Return(nil, nil)
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
 		When("the issue is open", func() {
 			It("it should set corresponding open condition", func() {
 				currentTicket := newExpectedGithubTicket()
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
-				mgc.EXPECT().IssueHasPR(currentTicket)
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -201,10 +463,10 @@ var _ = Describe("GithubissueController", func() {
 				currentTicket := newExpectedGithubTicket()
 				currentTicket.State = "closed"
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
-				mgc.EXPECT().IssueHasPR(currentTicket)
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -217,14 +479,182 @@ var _ = Describe("GithubissueController", func() {
 			})
 		})
 
+		When("the issue is closed by a merged PR", func() {
+			It("it should set the Resolved condition and record the merge", func() {
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.State = "closed"
+				currentTicket.StateReason = "completed"
+				mergedAt := time.Date(2022, 11, 5, 10, 0, 0, 0, time.UTC)
+
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number).Return([]gclient.LinkedPR{
+					{Number: 7, State: gclient.PR_STATE_CLOSED},
+					{Number: 8, State: gclient.PR_STATE_MERGED, MergeCommitSHA: "merge8", MergedAt: mergedAt},
+				}, nil)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "Resolved"),
+						HaveField("Status", metav1.ConditionTrue),
+						HaveField("Reason", "PRMerged"),
+					)))
+				Expect(underTest.Status.MergeCommitSHA).To(Equal("merge8"))
+				Expect(underTest.Status.MergedAt.Time.Equal(mergedAt)).To(BeTrue())
+			})
+		})
+
+		When("the issue number is set in the Spec", func() {
+			It("should adopt and update that issue", func() {
+				underTest.Spec.IssueNumber = 42
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.Number = 42
+				currentTicket.Title = "an existing issue"
+
+				mgc.EXPECT().GetTicket(gomock.Any(), underTest.Spec.Repo, int64(42)).Return(&currentTicket, nil)
+				want := newExpectedGithubTicket()
+				want.Number = 42
+				mgc.EXPECT().UpdateTicket(gomock.Any(), currentTicket, want).Return(nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, int64(42))
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.TrackedIssueId).To(BeEquivalentTo(42))
+			})
+
+			It("should not create an issue if it does not exist", func() {
+				underTest.Spec.IssueNumber = 42
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+
+				mgc.EXPECT().GetTicket(gomock.Any(), underTest.Spec.Repo, int64(42)).Return(nil, nil)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				result, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(Equal(terminalErrorRequeueAfter))
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "Synchronized"),
+						HaveField("Status", metav1.ConditionFalse),
+						HaveField("Reason", "IssueNotFound"),
+					)))
+			})
+		})
+
+		When("the state is set in the Spec", func() {
+			It("should close the open issue as completed", func() {
+				underTest.Spec.State = "closed"
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+				currentTicket := newExpectedGithubTicket()
+
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				want := newExpectedGithubTicket()
+				want.State = "closed"
+				want.StateReason = "completed"
+				mgc.EXPECT().UpdateTicket(gomock.Any(), currentTicket, want).Return(nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElements(
+					And(
+						HaveField("Type", "IsOpen"),
+						HaveField("Status", metav1.ConditionFalse),
+					),
+					And(
+						HaveField("Type", "Resolved"),
+						HaveField("Status", metav1.ConditionTrue),
+						HaveField("Reason", "Completed"),
+					)))
+			})
+
+			It("should reopen the closed issue", func() {
+				underTest.Spec.State = "open"
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.State = "closed"
+				currentTicket.StateReason = "not_planned"
+
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().UpdateTicket(gomock.Any(), currentTicket, newExpectedGithubTicket()).Return(nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "IsOpen"),
+						HaveField("Status", metav1.ConditionTrue),
+					)))
+			})
+
+			It("should not update the issue already in that state", func() {
+				underTest.Spec.State = "closed"
+				Expect(myClient.Update(context.Background(), underTest)).To(Succeed())
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.State = "closed"
+				currentTicket.StateReason = "completed"
+
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("the issue is closed as not planned", func() {
+			It("it should unset the Resolved condition", func() {
+				currentTicket := newExpectedGithubTicket()
+				currentTicket.State = "closed"
+				currentTicket.StateReason = "not_planned"
+
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "Resolved"),
+						HaveField("Status", metav1.ConditionFalse),
+						HaveField("Reason", "NotPlanned"),
+					)))
+				Expect(underTest.Status.MergedAt).To(BeNil())
+			})
+		})
+
 		When("the issue has a PR", func() {
 			It("it should set corresponding HasPr condition", func() {
 				currentTicket := newExpectedGithubTicket()
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
-				mgc.EXPECT().IssueHasPR(currentTicket).Return(true)
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number).Return([]gclient.LinkedPR{{
+					Number: 7, HTMLURL: "https://github.com/clobrano/githubissues-operator/pull/7", State: gclient.PR_STATE_OPEN,
+					Draft: true, Author: "alice", ReviewDecision: gclient.REVIEW_APPROVED,
+				}}, nil)
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -234,6 +664,40 @@ var _ = Describe("GithubissueController", func() {
 						HaveField("Type", "HasPr"),
 						HaveField("Status", metav1.ConditionTrue),
 					)))
+				Expect(underTest.Status.LinkedPullRequests).To(Equal([]v1alpha1.LinkedPullRequest{{
+					Number: 7, URL: "https://github.com/clobrano/githubissues-operator/pull/7", State: "open",
+					Draft: true, Author: "alice", ReviewDecision: "APPROVED",
+				}}))
+			})
+		})
+
+		When("the checks of a linked PR fail", func() {
+			It("it should unset the ChecksPassing condition", func() {
+				currentTicket := newExpectedGithubTicket()
+
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number).Return([]gclient.LinkedPR{
+					{Number: 7, State: gclient.PR_STATE_OPEN, HeadSHA: "sha7", Checks: gclient.CHECKS_SUCCESS},
+					{Number: 8, State: gclient.PR_STATE_OPEN, HeadSHA: "sha8", Checks: gclient.CHECKS_FAILURE},
+					{Number: 9, State: gclient.PR_STATE_CLOSED, HeadSHA: "sha9", Checks: gclient.CHECKS_PENDING},
+				}, nil)
+
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				_, err := r.Reconcile(context.TODO(), req)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "ChecksPassing"),
+						HaveField("Status", metav1.ConditionFalse),
+						HaveField("Reason", "ChecksFailing"),
+					)))
+				Expect(underTest.Status.LinkedPullRequests).To(ContainElement(
+					And(
+						HaveField("HeadSHA", "sha8"),
+						HaveField("Checks", "failure"),
+					)))
 			})
 		})
 
@@ -241,10 +705,10 @@ var _ = Describe("GithubissueController", func() {
 			It("it should unset corresponding HasPr condition", func() {
 				currentTicket := newExpectedGithubTicket()
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
-				mgc.EXPECT().IssueHasPR(currentTicket).Return(false)
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{currentTicket}, nil)
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, currentTicket.Number).Return(nil, nil)
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -298,13 +762,13 @@ var _ = Describe("GithubissueController", func() {
 
 				myClient.Create(ctx, underTest)
 
-				r := &GithubIssueReconciler{myClient, sch, mgc}
+				r := &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
 				ticket := newExpectedGithubTicket()
 				sameTicketButClosed := ticket
 				sameTicketButClosed.State = "closed"
 
-				mgc.EXPECT().GetTickets(underTest.Spec.Repo).Return([]gclient.GithubTicket{ticket}, nil).AnyTimes()
-				mgc.EXPECT().IssueHasPR(ticket).Return(false).AnyTimes()
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{ticket}, nil).AnyTimes()
+				mgc.EXPECT().GetLinkedPRs(gomock.Any(), underTest.Spec.Repo, ticket.Number).Return(nil, nil).AnyTimes()
 
 				_, err := r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
@@ -312,13 +776,137 @@ var _ = Describe("GithubissueController", func() {
 				Expect(myClient.Get(context.Background(), client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
 				Expect(controllerutil.ContainsFinalizer(underTest, GIFinalizer)).To(BeTrue())
 
-				mgc.EXPECT().UpdateTicket(sameTicketButClosed)
+				mgc.EXPECT().UpdateTicket(gomock.Any(), ticket, sameTicketButClosed)
 				myClient.Delete(ctx, underTest)
 
 				_, err = r.Reconcile(context.TODO(), req)
 				Expect(err).ToNot(HaveOccurred())
 			})
 		})
+
+		When("the deletion policy is set", func() {
+			var (
+				ctx    context.Context
+				r      *GithubIssueReconciler
+				ticket gclient.GithubTicket
+			)
+
+			BeforeEach(func() {
+				ctx = context.Background()
+				r = &GithubIssueReconciler{Client: myClient, Scheme: sch, RepoClient: mgc}
+				ticket = newExpectedGithubTicket()
+			})
+
+			// deleteWithPolicy deletes the resource with the finalizer already added
+			deleteWithPolicy := func(policy, comment string) {
+				underTest.Spec.DeletionPolicy = policy
+				underTest.Spec.DeletionComment = comment
+				controllerutil.AddFinalizer(underTest, GIFinalizer)
+				Expect(myClient.Update(ctx, underTest)).To(Succeed())
+				Expect(myClient.Delete(ctx, underTest)).To(Succeed())
+				if policy != v1alpha1.DELETION_POLICY_ORPHAN {
+					mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{ticket}, nil)
+				}
+			}
+
+			It("should close the ticket as not planned with CloseAsNotPlanned", func() {
+				deleteWithPolicy(v1alpha1.DELETION_POLICY_CLOSE_AS_NOT_PLANNED, "")
+				closed := ticket
+				closed.State = "closed"
+				closed.StateReason = "not_planned"
+				mgc.EXPECT().UpdateTicket(gomock.Any(), ticket, closed)
+
+				_, err := r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should comment and then close the ticket with CloseWithComment", func() {
+				deleteWithPolicy(v1alpha1.DELETION_POLICY_CLOSE_WITH_COMMENT, "Not tracked anymore")
+				closed := ticket
+				closed.State = "closed"
+				gomock.InOrder(
+					mgc.EXPECT().CommentTicket(gomock.Any(), ticket, "Not tracked anymore"),
+					mgc.EXPECT().UpdateTicket(gomock.Any(), ticket, closed),
+				)
+
+				_, err := r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should lock the ticket already closed with Lock", func() {
+				ticket.State = "closed"
+				deleteWithPolicy(v1alpha1.DELETION_POLICY_LOCK, "")
+				mgc.EXPECT().LockTicket(gomock.Any(), ticket)
+
+				_, err := r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should keep the finalizer and retry with backoff if Github fails", func() {
+				deleteWithPolicy(v1alpha1.DELETION_POLICY_CLOSE_WITH_COMMENT, "Not tracked anymore")
+				closed := ticket
+				closed.State = "closed"
+				mgc.EXPECT().CommentTicket(gomock.Any(), ticket, "Not tracked anymore")
+				mgc.EXPECT().UpdateTicket(gomock.Any(), ticket, closed).Return(fmt.Errorf("unreachable: %w", gclient.ErrTransient))
+
+				result, err := r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(Equal(5 * time.Second))
+
+				Expect(myClient.Get(ctx, client.ObjectKeyFromObject(underTest), underTest)).To(Succeed())
+				Expect(controllerutil.ContainsFinalizer(underTest, GIFinalizer)).To(BeTrue())
+				Expect(underTest.Status.DeletionPhase).To(Equal(v1alpha1.DELETION_PHASE_COMMENTED))
+				Expect(underTest.Status.DeletionAttempts).To(BeEquivalentTo(1))
+				Expect(underTest.Status.Conditions).To(ContainElement(
+					And(
+						HaveField("Type", "DeletionBlocked"),
+						HaveField("Status", metav1.ConditionTrue),
+						HaveField("Reason", "Transient"),
+					)))
+
+				// the comment is not added again
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return(nil, fmt.Errorf("unreachable: %w", gclient.ErrTransient))
+				result, err = r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(Equal(10 * time.Second))
+
+				mgc.EXPECT().GetTickets(gomock.Any(), underTest.Spec.Repo).Return([]gclient.GithubTicket{ticket}, nil)
+				mgc.EXPECT().UpdateTicket(gomock.Any(), ticket, closed)
+				_, err = r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(apierrors.IsNotFound(myClient.Get(ctx, client.ObjectKeyFromObject(underTest), underTest))).To(BeTrue())
+			})
+
+			It("should remove the finalizer without Github if forced by the annotation", func() {
+				underTest.Annotations = map[string]string{ForceDeletionAnnotation: "true"}
+				controllerutil.AddFinalizer(underTest, GIFinalizer)
+				Expect(myClient.Update(ctx, underTest)).To(Succeed())
+				Expect(myClient.Delete(ctx, underTest)).To(Succeed())
+
+				_, err := r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(apierrors.IsNotFound(myClient.Get(ctx, client.ObjectKeyFromObject(underTest), underTest))).To(BeTrue())
+			})
+
+			It("should remove the finalizer without Github after the timeout", func() {
+				r.DeletionTimeout = time.Nanosecond
+				controllerutil.AddFinalizer(underTest, GIFinalizer)
+				Expect(myClient.Update(ctx, underTest)).To(Succeed())
+				Expect(myClient.Delete(ctx, underTest)).To(Succeed())
+
+				_, err := r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(apierrors.IsNotFound(myClient.Get(ctx, client.ObjectKeyFromObject(underTest), underTest))).To(BeTrue())
+			})
+
+			It("should leave the ticket untouched with Orphan", func() {
+				deleteWithPolicy(v1alpha1.DELETION_POLICY_ORPHAN, "")
+
+				_, err := r.Reconcile(ctx, req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(apierrors.IsNotFound(myClient.Get(ctx, client.ObjectKeyFromObject(underTest), underTest))).To(BeTrue())
+			})
+		})
 	})
 })
 
@@ -343,6 +931,5 @@ func newExpectedGithubTicket() gclient.GithubTicket {
 		Body:          expectedIssueDescription,
 		State:         "open",
 		RepositoryURL: expectedUrl,
-		HasPr:         false,
 	}
 }
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	trainingv1alpha1 "github.com/clobrano/githubissues-operator/api/v1alpha1"
+	"github.com/clobrano/githubissues-operator/controllers/gclient"
+)
+
+// desiredMetadata returns the ticket with the labels, assignees and milestone of the Spec. Only the values
+// managed by the operator are removed, the ones set on Github by others are kept. The assignees that Github
+// did not assign are not requested again until the Spec assignees change.
+func desiredMetadata(gi *trainingv1alpha1.GithubIssue, ticket gclient.GithubTicket) gclient.GithubTicket {
+	var assignees []string
+	for _, a := range gi.Spec.Assignees {
+		if !containsFold(unassignableAssignees(gi), a) {
+			assignees = append(assignees, a)
+		}
+	}
+	ticket.Labels = mergeManaged(ticket.Labels, gi.Status.ManagedLabels, gi.Spec.Labels)
+	ticket.Assignees = mergeManaged(ticket.Assignees, gi.Status.ManagedAssignees, assignees)
+	if gi.Spec.Milestone != "" {
+		ticket.Milestone = gi.Spec.Milestone
+	} else if gi.Status.ManagedMilestone != "" && ticket.Milestone == gi.Status.ManagedMilestone {
+		ticket.Milestone = ""
+	}
+	return ticket
+}
+
+// desiredState returns the ticket with the state of the Spec, if set. An issue closed without a reason is
+// closed as completed.
+func desiredState(gi *trainingv1alpha1.GithubIssue, ticket gclient.GithubTicket) gclient.GithubTicket {
+	switch gi.Spec.State {
+	case "open":
+		if ticket.State != "open" {
+			// Github sets the "reopened" reason
+			ticket.State = "open"
+			ticket.StateReason = ""
+		}
+	case "closed":
+		if gi.Spec.StateReason != "" {
+			ticket.StateReason = gi.Spec.StateReason
+		} else if ticket.State != "closed" {
+			ticket.StateReason = "completed"
+		}
+		ticket.State = "closed"
+	}
+	return ticket
+}
+
+// stateChanged returns whether the state, or the reason of a closed state, of the tickets differ
+func stateChanged(a, b gclient.GithubTicket) bool {
+	return a.State != b.State || (b.State == "closed" && a.StateReason != b.StateReason)
+}
+
+// metadataChanged returns whether the labels, assignees or milestone of the tickets differ
+func metadataChanged(a, b gclient.GithubTicket) bool {
+	return !sameValues(a.Labels, b.Labels) || !sameValues(a.Assignees, b.Assignees) || a.Milestone != b.Milestone
+}
+
+// unassignableAssignees returns the assignees that Github did not assign, as long as the Spec assignees are
+// the ones last applied
+func unassignableAssignees(gi *trainingv1alpha1.GithubIssue) []string {
+	if !sameValues(gi.Status.ManagedAssignees, gi.Spec.Assignees) {
+		return nil
+	}
+	return gi.Status.UnassignableAssignees
+}
+
+// setMetadataStatus records the labels, assignees and milestone of the ticket, the ones managed by the operator,
+// and the assignees that Github did not assign, which are reported in the AssigneesDropped condition
+func setMetadataStatus(gi *trainingv1alpha1.GithubIssue, ticket gclient.GithubTicket, unassignable []string) {
+	if len(unassignable) > 0 {
+		meta.SetStatusCondition(&gi.Status.Conditions, metav1.Condition{
+			Type:    "AssigneesDropped",
+			Status:  metav1.ConditionTrue,
+			Reason:  "AssigneesCannotBeAssigned",
+			Message: fmt.Sprintf("Github did not assign %s, they cannot be assigned to the issues of the repository. They are requested again when the Spec assignees change", strings.Join(unassignable, ", ")),
+		})
+	} else {
+		meta.RemoveStatusCondition(&gi.Status.Conditions, "AssigneesDropped")
+	}
+	gi.Status.UnassignableAssignees = unassignable
+	gi.Status.Labels = ticket.Labels
+	gi.Status.Assignees = ticket.Assignees
+	gi.Status.Milestone = ticket.Milestone
+	gi.Status.ManagedLabels = gi.Spec.Labels
+	gi.Status.ManagedAssignees = gi.Spec.Assignees
+	gi.Status.ManagedMilestone = gi.Spec.Milestone
+}
+
+// mergeManaged removes from current the managed values that are no longer desired, and adds the desired ones.
+// Values are compared ignoring case, like Github does for labels and logins.
+func mergeManaged(current, managed, desired []string) []string {
+	var merged []string
+	for _, v := range current {
+		if containsFold(managed, v) && !containsFold(desired, v) {
+			continue
+		}
+		merged = append(merged, v)
+	}
+	for _, v := range desired {
+		if !containsFold(merged, v) {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
+
+// sameValues returns whether a and b have the same values, in any order
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, v := range a {
+		if !containsFold(b, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}